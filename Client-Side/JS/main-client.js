@@ -452,10 +452,13 @@ The window add event listner is not needed and breaks the code when the JS is ra
  function hideStartOverlay(){
      document.getElementById("overlay").style.display = "none";
  }
+ function escapeHTML(text){ // card text is sent as plain text, so it has to be escaped before it goes in innerHTML
+     return String(text).replace(/&/g, "&amp;").replace(/</g, "&lt;").replace(/>/g, "&gt;").replace(/"/g, "&quot;").replace(/'/g, "&#39;");
+ }
  function showBlackCard(){
      if(!gameData["black card"]) return;
      var blackCardArea = document.getElementById("blackCardArea");
-     blackCardArea.innerHTML = `<div class="card black" id="blackCard">${gameData["black card"].text}<hr>Pick: ${gameData["black card"]["cards to pick"]}</div>`;
+     blackCardArea.innerHTML = `<div class="card black" id="blackCard">${escapeHTML(gameData["black card"].text)}<hr>Pick: ${gameData["black card"]["cards to pick"]}</div>`;
  }
  function showCardsChosen(showWinner){
      var submittedCardsArea = document.getElementById("submittedCardsArea");
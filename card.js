@@ -9,6 +9,12 @@ module.exports = class Card {
     getID(){
         return this.cardID;
     }
+    copy(copyNumber){ // infinite decks give out copies of the white cards, each with its own ID, so two players can never hold the same card
+        return Object.assign(Object.create(Card.prototype), this, {"cardID": `${this.cardID}-${copyNumber}`, "original": this});
+    }
+    getOriginalID(){ // the ID of the card in the deck, for a copy that's the card it was copied from
+        return this.original ? this.original.getID() : this.cardID;
+    }
     getCardText(){
         return this.text;
     }
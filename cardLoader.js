@@ -0,0 +1,251 @@
+var fs = require('fs');
+var http = require('http');
+var https = require('https');
+const crypto = require('crypto');
+var striptags = require('striptags');
+
+// Each of these turns the JSON from a card file into {"white cards": [text], "black cards": [{"text", "cards to pick"}]}
+function parseTechSupportDeck(cards){ // cards.json
+    return {
+        "white cards": cards["white cards"],
+        "black cards": cards["black cards"].map(card => {
+            return {"text": card.text, "cards to pick": card.cards};
+        })
+    };
+}
+function parseJsonAgainstHumanity(cards){ // json-against-humanity/dev/cah.json
+    let tooLong = text => text.length > 100 || text.split(" ").find(word => word.length > 20); // removes all the really long cards
+    return {
+        "white cards": cards["whiteCards"].filter(text => !tooLong(text)).map(text => striptags(text)),
+        "black cards": cards["blackCards"].filter(card => !tooLong(card.text)).map(card => {
+            return {"text": striptags(card.text), "cards to pick": card.pick};
+        })
+    };
+}
+
+function parseFullFormat(packs){ // the "many decks" format, an array of packs with their own cards and details, each pack becomes its own deck
+    return {
+        "packs": packs.map(pack => {
+            return {
+                "name": striptags(pack.name),
+                "official": !!pack.official,
+                "description": pack.description ? striptags(pack.description) : null,
+                "icon": pack.icon || null,
+                "white cards": pack.white.map(card => striptags(typeof card == "string" ? card : card.text)),
+                "black cards": pack.black.map(card => {
+                    return {"text": striptags(card.text), "cards to pick": card.pick};
+                })
+            };
+        })
+    };
+}
+
+function parseAnyFormat(cards){ // for card files given in CARD_FILES, where the format isn't known
+    if(cards["white cards"]) return parseTechSupportDeck(cards);
+    if(cards["whiteCards"]) return parseJsonAgainstHumanity(cards);
+    if(Array.isArray(cards) && cards.every(pack => pack.name && pack.white && pack.black)) return parseFullFormat(cards);
+    throw new Error("unknown card file format");
+}
+function getCardFilesFromEnvironment(){
+    // CARD_FILES is a comma seperated list of paths or http(s) URLs, "#sha256=<hash>" can be put on the end of one to check it hasn't changed
+    // e.g. CARD_FILES="cards.json,https://example.com/cah.json#sha256=ab12..."
+    return process.env.CARD_FILES.split(",").map(source => source.trim()).filter(source => source.length > 0).map((source) => {
+        let [path, checksum] = source.split("#sha256=");
+        return {"path": path, "deck name": path.split("/").pop().replace(/\.json$/, ""), "nsfw": true, "parse": parseAnyFormat, "sha256": checksum};
+    });
+}
+const namedEntities = {"amp": "&", "lt": "<", "gt": ">", "quot": '"', "apos": "'", "nbsp": " ", "trade": "\u2122", "reg": "\u00AE", "copy": "\u00A9", "hellip": "...", "mdash": "\u2014", "ndash": "\u2013"};
+function sanitiseText(text){ // this is ran on every card, the text is kept as plain text and the client escapes it when it's shown
+    return striptags(String(text))
+        .replace(/&(#\d+|#x[0-9a-f]+|\w+);/gi, (entity, name) => { // some card files have things like &trade; in, they're turned back into the characters
+            if(name[0] != "#") return namedEntities[name.toLowerCase()] || entity;
+            let code = name[1].toLowerCase() == "x" ? parseInt(name.slice(2), 16) : parseInt(name.slice(1), 10);
+            return code <= 0x10FFFF ? String.fromCodePoint(code) : entity;
+        })
+        .replace(/[\u2018\u2019\u201A\u2032]/g, "'") // smart quotes are turned into normal ones
+        .replace(/[\u201C\u201D\u201E\u2033]/g, '"')
+        .replace(/[\u0000-\u001F\u007F-\u009F]/g, " ") // control characters, including new lines
+        .replace(/\s+/g, " ")
+        .trim();
+}
+function checkBlackCard(card){ // returns what is wrong with the black card, or an empty string if it's fine
+    let blanks = (card.text.match(/_+/g) || []).length; // "______" is one blank
+    if(!Number.isInteger(card["cards to pick"])) return "cards to pick is not a number";
+    if(blanks == 0 && (card["cards to pick"] < 1 || card["cards to pick"] > 3)) return `no blanks but ${card["cards to pick"]} cards to pick`; // cards like "Make a haiku." have no blanks
+    if(blanks > 0 && blanks != card["cards to pick"]) return `${blanks} blanks but ${card["cards to pick"]} cards to pick`;
+    return "";
+}
+function getCardID(deckName, cardsToPick, text){ // the ID comes from the card itself, so it's the same every time the file is loaded even if other cards are added or removed
+    // the deck name has to be in there, cardID is the Card table's primary key, so the same card in two decks would clash without it
+    // renaming a deck's file does change the IDs, but the loader finds decks by name, so it's a new deck with its own stats anyway
+    // moveOldDecks also uses this to tell the loaded decks apart from uploaded ones, 12 hex digits still fits in a javascript number
+    return parseInt(crypto.createHash('sha256').update(`${deckName}\n${cardsToPick}\n${text}`).digest('hex').slice(0, 12), 16);
+}
+function download(url, attemptsLeft, callback){ // gets the file at the URL, trying again a few times if it fails
+    (url.startsWith("https") ? https : http).get(url, (res) => {
+        if(res.statusCode != 200){
+            res.resume();
+            return retry(new Error(`status code ${res.statusCode}`));
+        }
+        let chunks = [];
+        res.on('data', chunk => chunks.push(chunk));
+        res.on('end', () => callback(null, Buffer.concat(chunks)));
+        res.on('error', retry);
+    }).on('error', retry);
+    function retry(err){
+        if(attemptsLeft <= 1) return callback(err);
+        console.log(`Error downloading ${url}, trying again: ${err}`);
+        setTimeout(() => download(url, attemptsLeft-1, callback), 2000);
+    }
+}
+
+// These are the files that are loaded into the database when the server starts, and again whenever the decks are reloaded
+const cardFiles = [
+    {"path": "cards.json", "deck name": "tech support deck", "nsfw": false, "parse": parseTechSupportDeck},
+    {"path": "json-against-humanity/dev/cah.json", "deck name": "lots of decks", "nsfw": true, "parse": parseJsonAgainstHumanity}
+];
+
+module.exports = class CardLoader {
+    constructor(db, sandbox){
+        this.db = db;
+        this.quarantine = []; // black cards that were left out when loading because they would break a round, for the admins to look at
+        this.loading = false;
+        this.waitingCallbacks = null; // when loadAll is called during a load, it waits and loads everything again afterwards, these are the callbacks for that
+        if(sandbox){
+            this.files = cardFiles.filter(file => file.path == "cards.json"); // the sandbox only has the small deck, so the cards are the same every time
+        } else {
+            this.files = process.env.CARD_FILES ? getCardFilesFromEnvironment() : cardFiles;
+        }
+    }
+    moveOldDecks(callback){ // the decks from card files used to belong to user 1, this takes them off user 1, it only runs once on each database
+        this.db.get("PRAGMA user_version", (err, row) => {
+            if(err) console.log(`Error checking the database version: ${err}`);
+            if(err || row.user_version >= 1) return callback();
+            // the cards loaded from files have IDs made from the deck name and the card, uploaded cards don't, so that's how user 1's own decks are told apart
+            this.db.all("SELECT Deck.deckID, Deck.name, Card.cardID, Card.cardsToPick, Card.cardText FROM Deck JOIN Card ON Card.deckID = Deck.deckID WHERE Deck.userID = 1 GROUP BY Deck.deckID", (err, rows) => {
+                if(err){
+                    console.log(`Error finding the old card file decks: ${err}`);
+                    return callback();
+                }
+                let deckIDs = rows.filter(row => row.cardID == getCardID(row.name, row.cardsToPick, row.cardText)).map(row => row.deckID);
+                this.db.serialize(() => {
+                    deckIDs.forEach(deckID => this.db.run("UPDATE Deck SET userID = NULL WHERE deckID = ?", [deckID]));
+                    this.db.run("PRAGMA user_version = 1", (err) => {
+                        if(err) console.log(`Error setting the database version: ${err}`);
+                        if(deckIDs.length > 0) console.log(`Moved ${deckIDs.length} card file decks off user 1`);
+                        callback();
+                    });
+                });
+            });
+        });
+    }
+    loadAll(callback){ // loads every card file, the callback is ran once they have all been loaded
+        if(this.loading){ // two loads at once would both be replacing the same decks, so this one goes after
+            this.waitingCallbacks = this.waitingCallbacks || [];
+            if(callback) this.waitingCallbacks.push(callback);
+            return;
+        }
+        this.loading = true;
+        let loadNext = (index) => { // one file at a time, so one deck's transaction has finished before the next one starts
+            if(index < this.files.length) return this.loadFile(this.files[index], () => loadNext(index+1));
+            this.loading = false;
+            if(callback) callback();
+            if(this.waitingCallbacks){
+                let callbacks = this.waitingCallbacks;
+                this.waitingCallbacks = null;
+                this.loadAll(() => callbacks.forEach(waiting => waiting()));
+            }
+        };
+        loadNext(0);
+    }
+    loadFile(file, done){
+        this.readFile(file, (err, data) => { // this opens the card file and returns the contents as "data"
+            if(err){
+                console.log(`Error reading file: ${err}`);
+                return done();
+            }
+            if(file.sha256 && crypto.createHash('sha256').update(data).digest('hex') != file.sha256.toLowerCase()){
+                console.log(`Error loading ${file.path}, the sha256 checksum does not match`);
+                return done();
+            }
+            try{ // if the file has been edited and the JSON is broken, the deck that is already loaded is kept
+                var cards = file.parse(JSON.parse(data));
+            } catch(e) {
+                console.log(`Error parsing card file ${file.path}: ${e}`);
+                return done();
+            }
+            this.quarantine = this.quarantine.filter(card => card.file != file.path); // the file is being loaded again, so its cards are checked again
+            (cards.packs || [cards]).forEach((set) => {
+                set["white cards"] = set["white cards"].map(sanitiseText).filter(text => text.length > 0);
+                set["black cards"].forEach(card => card.text = sanitiseText(card.text));
+                set["black cards"] = set["black cards"].filter((card) => {
+                    let problem = checkBlackCard(card);
+                    if(!problem) return true;
+                    console.log(`Black card left out of ${set.name || file["deck name"]}, ${problem}: ${card.text}`);
+                    this.quarantine.push({"file": file.path, "deck name": set.name || file["deck name"], "card text": card.text, "cards to pick": card["cards to pick"], "problem": problem});
+                    return false;
+                });
+            });
+            if(!cards.packs) return this.replaceDeck(file["deck name"], file.nsfw, cards, done);
+            let loadPack = (index) => { // files with packs in are loaded as one deck per pack, one after the other like the files
+                if(index == cards.packs.length) return done();
+                this.replaceDeck(cards.packs[index].name, file.nsfw, cards.packs[index], () => loadPack(index+1));
+            };
+            loadPack(0);
+        });
+    }
+    readFile(file, callback){ // card files can be on disk or on a web server
+        if(/^https?:\/\//.test(file.path)) return download(file.path, 3, callback);
+        fs.readFile(file.path, callback);
+    }
+    replaceDeck(name, nsfw, cards, done){ // this creates the deck if it doesn't exist yet, then swaps its cards for the new ones
+        // only the "many decks" format has the official flag, description and icon, the other formats leave them empty
+        let details = [!!cards.official, cards.description || null, cards.icon || null];
+        // the decks from card files have no owner, so nobody can log in and change them
+        this.db.get("SELECT deckID FROM Deck WHERE name = ? AND userID IS NULL", [name], (err, row) => {
+            if(err){
+                console.log(`Error finding deck: ${err}`);
+                return done();
+            }
+            if(row){
+                this.db.run("UPDATE Deck SET official = ?, description = ?, icon = ? WHERE deckID = ?", details.concat([row.deckID]), (err) => {
+                    if(err) console.log(`Error updating deck details: ${err}`);
+                });
+                return this.replaceCards(row.deckID, name, cards, done);
+            }
+            let loader = this;
+            this.db.run("INSERT INTO Deck (userID, time, name, public, nsfw, official, description, icon) VALUES (NULL, ?, ?, true, ?, ?, ?, ?)", [Date.now(), name, nsfw].concat(details), function(err) { // This creates the deck in the deck table
+                if(err){
+                    console.log(`Error creating deck: ${err}`);
+                    return done();
+                }
+                loader.replaceCards(this.lastID, name, cards, done); // lastID is the deckID of the new deck
+            });
+        });
+    }
+    replaceCards(deckID, deckName, cards, done){
+        // this is all in one transaction so if it fails part way through the deck isn't left with half of its cards
+        // it doesn't hide the change from other queries on this connection, so a game adding the deck during a reload could get some old cards and some new ones
+        // games that have already added the deck keep the cards they have, as they are loaded into the Deck class
+        this.db.serialize(() => {
+            this.db.run("BEGIN TRANSACTION");
+            this.db.run("DELETE FROM Card WHERE deckID = ?", [deckID]);
+            // OR IGNORE is for cards that are in the file twice, as they get the same ID
+            cards["white cards"].forEach((text) => {
+                this.db.run("INSERT OR IGNORE INTO Card (cardID, deckID, cardType, cardsToPick, cardText) VALUES (?, ?, true, 0, ?)", [getCardID(deckName, 0, text), deckID, text], (err) => {
+                    if(err) return console.log(`Error inserting card into datbase: ${err}`);
+                });
+            });
+            cards["black cards"].forEach((card) => {
+                this.db.run("INSERT OR IGNORE INTO Card (cardID, deckID, cardType, cardsToPick, cardText) VALUES (?, ?, false, ?, ?)", [getCardID(deckName, card["cards to pick"], card.text), deckID, card["cards to pick"], card.text], (err) => {
+                    if(err) return console.log(`Error inserting card into datbase: ${err}`);
+                });
+            });
+            this.db.run("COMMIT", (err) => {
+                if(err) console.log(`Error loading cards into deck ${deckID}: ${err}`);
+                else console.log(`Loaded ${cards["white cards"].length} white cards and ${cards["black cards"].length} black cards into deck ${deckID}`);
+                done();
+            });
+        });
+    }
+}
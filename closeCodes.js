@@ -0,0 +1,13 @@
+// The codes sent in the close frame when the server closes a websocket, so the client can show the right message instead of "disconnected"
+// 1000-1015 are the standard ones, 4000-4999 are for the game to use
+module.exports = {
+    "server shutdown": 1001, // "going away", the server is restarting, the client can reconnect and resume in a moment
+    "binary message": 1003, // "unsupported data", the protocol is JSON text only
+    "too many messages": 1008, // "policy violation"
+    "message too big": 1009, // ws sends this itself when a message is over MAX_MESSAGE_SIZE
+    "server full": 1013, // "try again later"
+    "send buffer full": 4000, // the connection was too slow to keep up, the client can resume with its session token
+    "session taken over": 4001, // the same account logged in on another connection, like a second tab
+    "idle timeout": 4002, // nothing was sent for too long, like a tab left open and forgotten about
+    "kicked": 4003 // the host or an admin removed them from their game, the client shouldn't resume
+};
@@ -1,62 +1,278 @@
 const User = require('./user.js');
 const Game = require('./game.js');
+const MemoryGameStore = require('./gameStore.js');
+const closeCodes = require('./closeCodes.js');
+var fs = require('fs');
+const crypto = require('crypto');
+var striptags = require('striptags');
+
+// the ORDER BY for each way the deck search can be sorted
+const deckSorts = {
+    "name": "Deck.name COLLATE NOCASE ASC",
+    "card count": "COUNT(Card.cardID) DESC",
+    "rating": "rating DESC, ratingCount DESC"
+};
+// games are removed when no one has done anything in them for longer than these, in milliseconds
+// the round timers don't count, so a game that's been left running on its own is still removed
+const maxGameInProgressAge = 30*60*1000; // 30 minutes while a game is being played
+const maxGameInLobbyAge = 60*60*1000; // an hour in the lobby, or after the last game finished
+const maxGameWithNoPlayersAge = 5*60*1000; // 5 minutes with no one connected
+const gameCleanupInterval = 60*1000;
+const heartbeatInterval = parseInt(process.env.HEARTBEAT_INTERVAL) || 30*1000; // how often every connection is pinged
+const heartbeatTimeout = heartbeatInterval*2; // connections that haven't answered a ping in this long are closed
+// connections that haven't sent a message or a ping in IDLE_TIMEOUT milliseconds are closed, pongs don't count as browsers send them by themselves
+const idleTimeout = parseInt(process.env.IDLE_TIMEOUT) || 30*60*1000;
+const resumeGracePeriod = 60*1000; // players who disconnect from a game have this long to reconnect before they're taken out of it
+// how many times each IP can do these a minute, going over blocks the IP from doing it for a few minutes
+const rateLimits = {
+    "create game": parseInt(process.env.RATE_LIMIT_CREATE_GAME) || 5,
+    "join private game": parseInt(process.env.RATE_LIMIT_JOIN_PRIVATE_GAME) || 10 // this stops people guessing passwords
+};
+const rateLimitWindow = 60*1000;
+const rateLimitBlockTime = 5*60*1000;
+const joinCodeCharacters = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"; // no 0, O, 1 or I as they're easy to mix up when saying the code out loud
+const joinCodeLength = 6;
+const reservedNames = ["server", "system", "czar", "host", "admin", "guest"]; // names that could be confused with messages from the game
 
 module.exports = class Container {
-    constructor(wss, db){
+    constructor(wss, db, cardLoader, sandbox, gameStore = new MemoryGameStore()){
         // *********** initialising the attributes ***********
         this.db = db;
+        this.sandbox = sandbox; // sandbox mode is for developing the client, limits are turned off and every message is logged
+        this.cardLoader = cardLoader;
         this.users = [];
         this.guests = 0;
-        this.disconnectedUsers = [];
-        this.games = [];
-        this.publicDecks = [];
-        this.updatePublicDecks();
+        this.disconnectedUsers = []; // users who were in a game when they disconnected, they can resume with their session token
+        this.games = gameStore; // use this.games.list() to get the array of games
+        this.bannedWords = [];
+        this.moderationQueue = []; // reports from players waiting for an admin to look at them
+        this.checksumMismatches = 0; // how many times a client has asked for a resync because the game checksum was wrong
+        this.maxGames = parseInt(process.env.MAX_GAMES) || Infinity; // when the server is full, new games and connections are turned away
+        this.maxPlayers = parseInt(process.env.MAX_PLAYERS) || Infinity;
+        this.rateLimits = new Map(); // IP => {action => [times], "blocked until" => {action => time}}
+        this.messagesReceived = 0; // these three are for the metrics, rate() of them gives messages per second
+        this.messagesSent = 0;
+        this.sendErrors = 0;
+        this.droppedMessages = 0; // messages not sent because the connection was too slow
+        this.messagesRateLimited = 0; // messages ignored because the connection sent too many
+        this.gamesCreated = 0; // these two are for the metrics
+        this.gamesRemoved = 0;
+        this.gameCleanupTimer = setInterval(() => this.cleanUpGames(), gameCleanupInterval);
+        this.heartbeatTimer = setInterval(() => this.checkHeartbeats(), heartbeatInterval);
+        this.pausedGames = []; // snapshots of games from before a restart, waiting for their players to log in
+        if(process.env.SNAPSHOT_PATH) this.loadSnapshots(process.env.SNAPSHOT_PATH);
+        this.defaultDeckID = parseInt(process.env.DEFAULT_DECK_ID) || null; // the deck new games start with if there's no official base deck
+        this.loadBannedWords(process.env.BANNED_WORDS_FILE || 'bannedWords.txt');
         // *********** Websocket management ***********
-        wss.on('connection', (ws) => { // Whenever there is a new connection, a new user is created
-            this.users.push(new User(ws, this));
-            console.log(`new websocket connection! Total Connected: ${this.users.length}`);
+        wss.on('connection', (ws, req) => { // Whenever there is a new connection, a new user is created
+            if(this.users.length >= this.maxPlayers) return ws.close(closeCodes["server full"], "server full, try again later");
+            let user = new User(ws, this);
+            // behind a proxy like nginx every connection comes from the proxy, so TRUST_PROXY makes it use the IP the proxy says instead
+            user.ip = process.env.TRUST_PROXY && req.headers["x-forwarded-for"] ? req.headers["x-forwarded-for"].split(",")[0].trim() : req.socket.remoteAddress;
+            this.users.push(user);
+            user.log(`new websocket connection! Total Connected: ${this.users.length}`);
         });
         wss.on('error', (err) => { // whenever there is an error, it is logged to the console
             console.log(`Websocket Error: ${err}`);
         });
     }
     getGames(){ // This is to get the games to send to the user
-        return this.games.map(game => {return {"name": game.getGameName(), "players": game.players.length, "host": game.host.username, "private": game.private, "rounds": game.rounds, "round": game.round, "joinable": game.joinable, "decks added": game.getDecksAdded(), "status": game.status}});
+        return this.games.list().filter(game => game.public).map(game => this.getGameInfo(game)); // games that aren't public are only joined with their name
+    }
+    checkRateLimit(user, action){ // this counts the attempt, it returns false and tells the user if they've done it too much
+        if(this.sandbox) return true; // limits are off in the sandbox
+        let now = Date.now();
+        if(!this.rateLimits.has(user.ip)) this.rateLimits.set(user.ip, {"blocked until": {}});
+        let limits = this.rateLimits.get(user.ip);
+        if(limits["blocked until"][action] > now){
+            user.returnMessage("error", false, `Too Many Attempts! Try Again In ${Math.ceil((limits["blocked until"][action]-now)/60000)} Minutes`, "rate limited");
+            return false;
+        }
+        limits[action] = (limits[action] || []).filter(time => now-time < rateLimitWindow);
+        limits[action].push(now);
+        if(limits[action].length > rateLimits[action]){
+            limits["blocked until"][action] = now+rateLimitBlockTime;
+            user.log(`${user.ip} has been blocked from ${action} for too many attempts`);
+            user.returnMessage("error", false, `Too Many Attempts! Try Again In ${rateLimitBlockTime/60000} Minutes`, "rate limited");
+            return false;
+        }
+        return true;
+    }
+    archiveSummary(summary){ // finished games are kept in the database, the ID is added to the summary so the players can share it
+        summary["archive id"] = crypto.randomBytes(8).toString('hex');
+        this.db.run("INSERT INTO Game_Archive (archiveID, gameName, finishedAt, summary) VALUES (?, ?, ?, ?)", [summary["archive id"], summary["game name"], summary["finished at"], JSON.stringify(summary)], (err) => {
+            if(err) console.log(`Error archiving game: ${err}`);
+        });
+    }
+    sendArchivedGame(user, archiveID){
+        this.db.get("SELECT summary FROM Game_Archive WHERE archiveID = ?", [archiveID], (err, row) => {
+            if(err) return console.log(`Error with get archived game SQL query: ${err}`);
+            if(!row) return user.returnMessage("error", false, "That Game Could Not Be Found!", "game not found");
+            user.returnMessage("update", true, {"archived game": JSON.parse(row.summary)});
+        });
+    }
+    makeRoomForGame(){ // if there are too many games, the oldest lobby with no one connected is removed, it returns false if there's no room
+        if(this.games.list().length < this.maxGames) return true;
+        let emptyLobbies = this.games.list().filter(game => ["setup", "finished"].includes(game.status) && !game.players.find(player => player.user.ws.readyState == 1));
+        if(emptyLobbies.length == 0) return false;
+        let oldest = emptyLobbies.reduce((oldest, game) => game.createdAt < oldest.createdAt ? game : oldest);
+        console.log(`Server full, removing empty game ${oldest.gameName}`);
+        this.removeGame(oldest);
+        return true;
+    }
+    makeJoinCode(){ // a short code that's easier to share than the game name
+        let code;
+        do {
+            code = Array.from(crypto.randomBytes(joinCodeLength), byte => joinCodeCharacters[byte % joinCodeCharacters.length]).join("");
+        } while(this.games.getByJoinCode(code)); // it's tried again in the very unlikely case that it's already used
+        return code;
+    }
+    getMetrics(){ // this is in the Prometheus text format, for the /metrics page
+        let games = this.games.list();
+        let lines = [
+            "# HELP cah_games_active Games that are currently running or in the lobby",
+            "# TYPE cah_games_active gauge",
+            `cah_games_active ${games.length}`,
+            "# HELP cah_games Games by status",
+            "# TYPE cah_games gauge"
+        ];
+        ["setup", "choosing white cards", "choosing winner", "finished"].forEach((status) => {
+            lines.push(`cah_games{status="${status}"} ${games.filter(game => game.status == status).length}`);
+        });
+        lines.push(
+            "# HELP cah_players_connected Websocket connections that are open",
+            "# TYPE cah_players_connected gauge",
+            `cah_players_connected ${this.users.length}`,
+            "# HELP cah_players_in_games Players that are in a game",
+            "# TYPE cah_players_in_games gauge",
+            `cah_players_in_games ${games.reduce((total, game) => total+game.players.length, 0)}`,
+            "# HELP cah_games_created_total Games made since the server started, rate() of this gives games per minute",
+            "# TYPE cah_games_created_total counter",
+            `cah_games_created_total ${this.gamesCreated}`,
+            "# HELP cah_games_removed_total Games removed since the server started",
+            "# TYPE cah_games_removed_total counter",
+            `cah_games_removed_total ${this.gamesRemoved}`,
+            "# HELP cah_send_buffer_bytes Bytes waiting to be sent on all the connections",
+            "# TYPE cah_send_buffer_bytes gauge",
+            `cah_send_buffer_bytes ${this.users.reduce((total, user) => total+user.ws.bufferedAmount, 0)}`,
+            "# HELP cah_send_buffer_max_bytes The most bytes waiting to be sent on one connection",
+            "# TYPE cah_send_buffer_max_bytes gauge",
+            `cah_send_buffer_max_bytes ${this.users.reduce((max, user) => Math.max(max, user.ws.bufferedAmount), 0)}`,
+            "# HELP cah_messages_dropped_total Messages not sent because the connection was too slow",
+            "# TYPE cah_messages_dropped_total counter",
+            `cah_messages_dropped_total ${this.droppedMessages}`,
+            "# HELP cah_messages_rate_limited_total Messages ignored because the connection was sending too many",
+            "# TYPE cah_messages_rate_limited_total counter",
+            `cah_messages_rate_limited_total ${this.messagesRateLimited}`,
+            "# HELP cah_messages_received_total Messages received from all the connections",
+            "# TYPE cah_messages_received_total counter",
+            `cah_messages_received_total ${this.messagesReceived}`,
+            "# HELP cah_messages_sent_total Messages sent to all the connections",
+            "# TYPE cah_messages_sent_total counter",
+            `cah_messages_sent_total ${this.messagesSent}`,
+            "# HELP cah_send_errors_total Messages that failed to send",
+            "# TYPE cah_send_errors_total counter",
+            `cah_send_errors_total ${this.sendErrors}`,
+            "# HELP cah_latency_average_milliseconds The average time for a ping to get there and back, over the connections that have answered one",
+            "# TYPE cah_latency_average_milliseconds gauge",
+            `cah_latency_average_milliseconds ${this.getAverageLatency()}`,
+            "# HELP cah_checksum_mismatches_total Resyncs asked for because a client's game checksum was wrong",
+            "# TYPE cah_checksum_mismatches_total counter",
+            `cah_checksum_mismatches_total ${this.checksumMismatches}`
+        );
+        lines.push(
+            "# HELP cah_game_connections Open connections in each game",
+            "# TYPE cah_game_connections gauge"
+        );
+        games.forEach((game) => {
+            let name = game.gameName.replace(/\\/g, "\\\\").replace(/"/g, '\\"').replace(/\n/g, "\\n"); // the label value escaping Prometheus needs
+            lines.push(`cah_game_connections{game="${name}"} ${game.players.filter(player => player.user.ws.readyState == 1).length}`);
+        });
+        return lines.join("\n")+"\n";
+    }
+    getAverageLatency(){
+        let latencies = this.users.filter(user => user.latency !== null).map(user => user.latency);
+        if(latencies.length == 0) return 0;
+        return Math.round(latencies.reduce((total, latency) => total+latency, 0)/latencies.length);
+    }
+    getConnections(){ // every connection, for the /debug/connections page
+        return this.users.map((user) => {
+            let game = user.getGame();
+            return {"connection id": user.connectionID, "username": user.username, "ip": user.ip, "game": game ? game.gameName : null, "state": user.ws.readyState, "disconnected at": user.disconnectedAt, "buffered bytes": user.ws.bufferedAmount, "latency": user.latency, "last pong at": user.lastPongAt, "messages received": user.messageCount, "messages sent": user.sequence, "protocol version": user.protocolVersion};
+        });
+    }
+    getGameInfo(game){ // this is what's shown about each game on the games page
+        return {"name": game.getGameName(), "players": game.players.length, "host": game.host.username, "private": game.private, "rounds": game.rounds, "round": game.round, "win condition": game.winCondition, "family friendly": game.familyFriendly, "joinable": game.joinable, "decks added": game.getDecksAdded(), "status": game.status, "created at": game.createdAt, "public": game.public};
+    }
+    listGames(user, page, pageSize, joinableOnly, search){ // a page of the games, newest first, for when there are too many to send them all
+        let games = this.games.list().filter(game => game.public);
+        if(search) games = games.filter(game => game.gameName.toLowerCase().includes(search.toLowerCase()));
+        if(joinableOnly) games = games.filter(game => game.status == "setup" && game.joinable && !game.private); // games that can be joined without a password right now
+        games.sort((a, b) => b.createdAt-a.createdAt);
+        user.returnMessage("update", true, {"game list": {"games": games.slice(page*pageSize, (page+1)*pageSize).map(game => this.getGameInfo(game)), "page": page, "page size": pageSize, "total": games.length}});
+    }
+    loadBannedWords(path){ // the file has one word per line, if there is no file, no words are banned
+        fs.readFile(path, 'utf8', (err, data) => {
+            if(err) return console.log(`No banned words loaded, could not read ${path}: ${err}`);
+            this.bannedWords = data.split(/\r?\n/).map(word => word.trim().toLowerCase()).filter(word => word.length > 0);
+            console.log(`Loaded ${this.bannedWords.length} banned words from ${path}`);
+        });
+    }
+    checkName(name){ // returns why the username isn't allowed, or an empty string if it is
+        if(typeof name != "string") return "Invalid Username!"; // it comes from the client, so it could be anything
+        let normalised = name.toLowerCase().replace(/[^a-z0-9]/g, ""); // so "S e r v e r" or "czar!" are still caught
+        if(reservedNames.find(reserved => new RegExp(`^${reserved}[0-9]*$`).test(normalised))) return "That Username Is Reserved!"; // "Guest 12" is reserved too, as guest names are given out by the server
+        if(this.containsBannedWord(name)) return "That Username Is Not Allowed!";
+        return "";
+    }
+    containsBannedWord(text){ // anything that isn't a string counts as banned, so it's refused
+        if(typeof text != "string") return true;
+        let normalised = text.toLowerCase().replace(/[^a-z0-9]/g, "");
+        return this.bannedWords.some(word => normalised.includes(word.replace(/[^a-z0-9]/g, "")));
+    }
+    reportContent(report){ // adds to the list of things reported by players, for the admins to look at
+        report.time = Date.now();
+        this.moderationQueue.push(report);
+        console.log(`Content reported: ${JSON.stringify(report)}`);
     }
     getGuestUsername(){
         this.guests++;
         var username = `Guest ${this.guests}`;
         return username;
     }
-    createNewGame(user, name, password){
+    createNewGame(user, name, password, bundleID){
         name = name.replace(/['"\t\n\r]+/g, '').replace(/\s/g, "-");
         user.returnMessage("done", true, "game created");
-        this.games.push(new Game(user, this, name, password));
+        let game = new Game(user, this, name, password);
+        if(bundleID) game.addBundle(bundleID, user); // the decks in the bundle are added instead of the default deck
+        this.games.put(game);
+        this.gamesCreated++;
         this.sendGamesUpdate(); // This sends the updated games array to the people on the home page
     }
-    sendDecksAvailable(user){
-        this.db.all("SELECT * FROM Deck WHERE public = true OR userID = ?", [user.userID], (err, rows) => {
+    sendDecksAvailable(user){ // the cards are only counted here, they're loaded when a deck is added to a game
+        this.db.all("SELECT Deck.*, IFNULL(SUM(Card.cardType), 0) AS whiteCardCount, COUNT(Card.cardID) AS cardCount FROM Deck LEFT JOIN Card ON Card.deckID = Deck.deckID WHERE (Deck.public = true AND Deck.approved = true) OR Deck.userID = ? GROUP BY Deck.deckID", [user.userID], (err, rows) => {
             if(err) return console.log(`Error with get decks SQL query: ${err}`);
-            this.db.serialize(() => {
-                let deckArray = [];
-                let decksToGo = rows.length;
-                for(var i=0;i<rows.length;i++){
-                    let deck = rows[i];
-                    this.db.all("SELECT * FROM Card WHERE deckID = ?", deck.deckID, (err, rows) => {
-                        if(err) return console.log(`Error with get decks SQL query: ${err}`);
-                        let whiteCardCount = rows.filter(card => card.cardType).length;
-                        let blackCardCount = rows.length-whiteCardCount;
-                        deckArray.push({"name": deck.name, "deckID": deck.deckID, "white card count": whiteCardCount, "black card count": blackCardCount, "private": deck.private});
-                        if(deckArray.length == decksToGo) {
-                            user.returnMessage("update", true, {"decks available": deckArray});
-                        } 
-                    });
-                }
+            let deckArray = rows.map((deck) => {
+                return {"name": deck.name, "deckID": deck.deckID, "white card count": deck.whiteCardCount, "black card count": deck.cardCount-deck.whiteCardCount, "private": deck.private, "nsfw": !!deck.nsfw, "official": !!deck.official, "description": deck.description, "icon": deck.icon};
             });
+            user.returnMessage("update", true, {"decks available": deckArray});
         });
     }
+    getDefaultDeck(callback){ // this is the biggest official base deck, the name is used if two are the same size so it's the same deck every time
+        this.db.get("SELECT Deck.deckID FROM Deck JOIN Card ON Card.deckID = Deck.deckID WHERE Deck.official = true AND Deck.public = true AND Deck.approved = true AND Deck.name LIKE '%base%' GROUP BY Deck.deckID ORDER BY COUNT(Card.cardID) DESC, Deck.name LIMIT 1", (err, row) => {
+            if(err) console.log(`Error with get default deck SQL query: ${err}`);
+            callback(row ? row.deckID : this.defaultDeckID);
+        });
+    }
+    closeAll(code, reason){ // for shutting down, every client is told why before the connection goes
+        this.users.forEach(user => user.ws.close(code, reason));
+    }
+    // removeUser and removeGame are the only places users and games are taken down, and both can be reached more than once
+    // (like a game emptying while its last player is being removed), so they only do it the first time
     removeUser(user){
-        user.username.length > 0 ? console.log(`User Removed, username: ${user.username}`) : console.log(`User Removed`);
+        if(user.removed) return;
+        user.removed = true;
+        user.username.length > 0 ? user.log(`User Removed, username: ${user.username}`) : user.log(`User Removed`);
         let userGame = user.getGame();
         if(userGame){
             if(userGame.players.length < 2){
@@ -81,7 +297,7 @@ module.exports = class Container {
         return count;
     }
     getGamesCount(){ // like the getUserCount function, this is for showing the user on the login page how many games are running
-        return this.games.length;
+        return this.games.list().length;
     }
     printDatabase(){ // this function is for debugging and finding errors in the database, it prints all the tables
         this.db.each("SELECT * FROM User", function(err, row) {
@@ -99,32 +315,372 @@ module.exports = class Container {
     }
     incomingRequest(user, data){ // this function handles whenever the user requests on the websocket, its for creating games mainly
         if(data.request == "create game"){
-            if(!user.signedIn) return user.returnMessage("error", true, "cant create game when user is not signed in");
-            if(!data["game name"]) user.returnMessage("error", true, "no game name");
-            data["game name"] = data["game name"].trim();
+            if(!user.signedIn) return user.returnMessage("error", true, "cant create game when user is not signed in", "not signed in");
+            if(typeof data["game name"] != "string") return user.returnMessage("error", true, "no game name");
+            data["game name"] = striptags(data["game name"]).trim(); // the name is shown on everyone's games page, so it can't have HTML in
             if(!(data["game name"].length > 5 && data["game name"].length < 25)) return user.returnMessage("error", true, "invalid game name length");
-            if(user.getGame()) return user.returnMessage("error", true, "user already in game");
-            if(this.games.find(game => game.gameName == data["game name"])) return user.returnMessage("error", false, "A Game With That Name Already Exists!");
-            if(data.password){
-                if(data.password.length > 30 || data.password.length < 3) return user.returnMessage("error", true, "invalid request, password lenght not within range");
-                this.createNewGame(user, data["game name"], data.password);
-            } else {
-                this.createNewGame(user, data["game name"]);
+            if(user.getGame()) return user.returnMessage("error", true, "user already in game", "already in game");
+            if(this.games.get(data["game name"])) return user.returnMessage("error", false, "A Game With That Name Already Exists!", "game name taken");
+            if(!this.checkRateLimit(user, "create game")) return;
+            if(data.bundleID !== undefined && !Number.isInteger(data.bundleID)) return user.returnMessage("error", true, "invalid request, invalid bundleID");
+            if(data.password && (data.password.length > 30 || data.password.length < 3)) return user.returnMessage("error", true, "invalid request, password lenght not within range", "invalid game password");
+            let create = () => {
+                if(!this.makeRoomForGame()) return user.returnMessage("error", false, "The Server Is Full, Try Again In A Minute!", "server full"); // this can close another lobby, so it's after everything else is checked
+                this.createNewGame(user, data["game name"], data.password || undefined, data.bundleID);
+            };
+            if(data.bundleID === undefined) return create();
+            this.db.get("SELECT bundleID FROM Bundle_Deck WHERE bundleID = ?", [data.bundleID], (err, row) => { // the bundle is checked before making room too
+                if(err) return console.log(`Error with find bundle SQL query: ${err}`);
+                if(!row) return user.returnMessage("error", false, "That Bundle Does Not Exist!", "bundle not found");
+                // these are checked again as things could have changed while the bundle was looked up
+                if(user.getGame()) return user.returnMessage("error", true, "user already in game", "already in game");
+                if(this.games.get(data["game name"])) return user.returnMessage("error", false, "A Game With That Name Already Exists!", "game name taken");
+                create();
+            });
+        } else if(data.request == "list games"){
+            let page = data.page || 0;
+            let pageSize = data.pageSize || 20;
+            if(!Number.isInteger(page) || page < 0) return user.returnMessage("error", true, "invalid request, invalid page");
+            if(!Number.isInteger(pageSize) || pageSize < 1 || pageSize > 50) return user.returnMessage("error", true, "invalid request, page size invalid range");
+            if(data.joinable !== undefined && typeof data.joinable != "boolean") return user.returnMessage("error", true, "invalid request, joinable should be true or false");
+            if(data.search !== undefined && typeof data.search != "string") return user.returnMessage("error", true, "invalid request, search should be a string");
+            this.listGames(user, page, pageSize, !!data.joinable, (data.search || "").trim());
+        } else if(data.request == "archived game"){ // anyone with the archive ID can see the results, so they can be shared
+            if(typeof data.archiveID != "string") return user.returnMessage("error", true, "invalid request, no archiveID");
+            this.sendArchivedGame(user, data.archiveID);
+        } else if(data.request == "bundles"){
+            this.sendBundles(user);
+        } else if(data.request == "create bundle"){ // bundles are a few decks that can be added to a game together, like "Base + UK"
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            if(typeof data.name != "string" || data.name.trim().length < 3 || data.name.trim().length > 30) return user.returnMessage("error", true, "invalid request, invalid bundle name");
+            if(!Array.isArray(data.deckIDs) || data.deckIDs.length == 0 || !data.deckIDs.every(Number.isInteger)) return user.returnMessage("error", true, "invalid request, deckIDs should be an array of deck IDs");
+            this.createBundle(user, striptags(data.name).trim(), data.deckIDs);
+        } else if(data.request == "delete bundle"){
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            if(!data.bundleID) return user.returnMessage("error", true, "invalid request, no bundleID");
+            this.deleteBundle(user, data.bundleID);
+        } else if(data.request == "preview deck"){ // a few random cards so people can see what a deck is like before adding it
+            let count = data.count || 5;
+            if(!data.deckID) return user.returnMessage("error", true, "invalid request, no deckID");
+            if(!Number.isInteger(count) || count < 1 || count > 20) return user.returnMessage("error", true, "invalid request, count invalid range");
+            this.previewDeck(user, data.deckID, count);
+        } else if(data.request == "search decks"){
+            if(data.search !== undefined && typeof data.search != "string") return user.returnMessage("error", true, "invalid request, search should be a string");
+            let page = data.page || 0;
+            let pageSize = data.pageSize || 20;
+            if(!Number.isInteger(page) || page < 0) return user.returnMessage("error", true, "invalid request, invalid page");
+            if(!Number.isInteger(pageSize) || pageSize < 1 || pageSize > 50) return user.returnMessage("error", true, "invalid request, page size invalid range");
+            if(data.sort && !deckSorts.hasOwnProperty(data.sort)) return user.returnMessage("error", true, "invalid request, invalid sort");
+            this.searchDecks(user, data.search || "", page, pageSize, data.sort || "name");
+        } else if(data.request == "rate deck"){
+            if(user.userID == -1) return user.returnMessage("error", false, "You Need To Be Registered To Rate Decks!", "not signed in"); // guests can't rate, otherwise anyone could rate a deck as many times as they want
+            if(!data.deckID) return user.returnMessage("error", true, "invalid request, no deckID");
+            if(!Number.isInteger(data.rating) || data.rating < 1 || data.rating > 5) return user.returnMessage("error", true, "invalid request, rating should be 1 to 5");
+            this.rateDeck(user, data.deckID, data.rating);
+        } else if(data.request == "favourite deck"){
+            if(user.userID == -1) return user.returnMessage("error", false, "You Need To Be Registered To Favourite Decks!", "not signed in");
+            if(!data.deckID) return user.returnMessage("error", true, "invalid request, no deckID");
+            if(typeof data.favourite != "boolean") return user.returnMessage("error", true, "invalid request, favourite should be true or false");
+            this.favouriteDeck(user, data.deckID, data.favourite);
+        } else if(data.request == "reload decks"){
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            this.cardLoader.loadAll(() => user.returnMessage("done", false, "Decks Reloaded!"));
+        } else if(data.request == "quarantined cards"){ // black cards the card loader left out
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            user.returnMessage("update", true, {"quarantined cards": this.cardLoader.quarantine});
+        } else if(data.request == "admin games"){ // every game including ones that aren't public, with more detail than the games page
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            user.returnMessage("update", true, {"admin games": this.games.list().map(game => Object.assign(this.getGameInfo(game), {"join code": game.joinCode, "connected players": game.players.filter(player => player.user.ws.readyState == 1).length, "started at": game.startedAt, "cards remaining": game.getCardsRemaining()}))});
+        } else if(data.request == "admin game state" || data.request == "end game" || data.request == "kick player"){
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            let game = this.games.get(data["game name"]);
+            if(!game) return user.returnMessage("error", false, "That Game Does Not Exist!", "game not found");
+            if(data.request == "admin game state") return user.returnMessage("update", true, {"admin game state": game.getAdminState()});
+            if(data.request == "end game"){
+                user.log(`${user.username} ended game ${game.gameName}`);
+                this.removeGame(game);
+                return user.returnMessage("done", false, "Game Ended!");
             }
-            
+            let player = game.players.find(player => player.user.username == data.username);
+            if(!player) return user.returnMessage("error", false, "That Player Is Not In The Game!", "player not found");
+            user.log(`${user.username} kicked ${data.username} from ${game.gameName}`);
+            game.kickPlayer(player, "removed by an admin");
+            user.returnMessage("done", false, "Player Kicked!");
+        } else if(data.request == "moderation queue"){
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            this.sendModerationQueue(user);
+        } else if(data.request == "moderate deck"){ // approve puts a published deck in the public list, otherwise it's taken out of it
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            if(!data.deckID || typeof data.approve != "boolean") return user.returnMessage("error", true, "invalid request");
+            this.moderateDeck(user, data.deckID, data.approve);
+        } else if(data.request == "draw log"){ // admins can see any game's draw log, the host can get it from the game
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            let game = this.games.get(data["game name"]);
+            if(!game) return user.returnMessage("error", false, "That Game Does Not Exist!", "game not found");
+            user.returnMessage("update", true, {"draw log": {"game name": game.gameName, "draws": game.drawLog}});
+        } else if(data.request == "card stats"){
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            if(!data.deckID) return user.returnMessage("error", true, "invalid request, no deckID");
+            this.sendCardStats(user, data.deckID);
+        } else if(data.request == "pack stats"){ // the card stats added up for each deck, so the best decks can be found
+            if(!user.admin) return user.returnMessage("error", true, "invalid request, user is not an admin");
+            this.sendPackStats(user);
         } else if(data.request == "***PLACEHOLDER***"){
 
         } else {
             return user.returnMessage("error", true, "invalid request");
         }
     }
+    searchDecks(user, search, page, pageSize, sort){ // this is so the client doesn't need every deck to find one
+        let where = "WHERE ((Deck.public = true AND Deck.approved = true) OR Deck.userID = ?) AND Deck.name LIKE ? ESCAPE '\\'";
+        let params = [user.userID, `%${search.replace(/[\\%_]/g, "\\$&")}%`]; // % and _ are escaped so they're searched for like normal characters
+        this.db.get(`SELECT COUNT(*) AS total FROM Deck ${where}`, params, (err, countRow) => {
+            if(err) return console.log(`Error with search decks count SQL query: ${err}`);
+            let ratings = "(SELECT AVG(rating) FROM Deck_Rating WHERE deckID = Deck.deckID) AS rating, (SELECT COUNT(*) FROM Deck_Rating WHERE deckID = Deck.deckID) AS ratingCount, EXISTS(SELECT 1 FROM Deck_Favourite WHERE deckID = Deck.deckID AND userID = ?) AS favourite";
+            this.db.all(`SELECT Deck.deckID, Deck.name, Deck.nsfw, Deck.official, Deck.description, Deck.icon, SUM(Card.cardType) AS whiteCardCount, COUNT(Card.cardID) AS cardCount, ${ratings} FROM Deck LEFT JOIN Card ON Card.deckID = Deck.deckID ${where} GROUP BY Deck.deckID ORDER BY ${deckSorts[sort]} LIMIT ? OFFSET ?`, [user.userID].concat(params, [pageSize, page*pageSize]), (err, rows) => {
+                if(err) return console.log(`Error with search decks SQL query: ${err}`);
+                let decks = rows.map(deck => {
+                    let whiteCardCount = deck.whiteCardCount || 0;
+                    return {"name": deck.name, "deckID": deck.deckID, "white card count": whiteCardCount, "black card count": deck.cardCount-whiteCardCount, "nsfw": !!deck.nsfw, "official": !!deck.official, "description": deck.description, "icon": deck.icon, "rating": deck.rating, "rating count": deck.ratingCount, "favourite": !!deck.favourite};
+                });
+                user.returnMessage("update", true, {"deck search": {"decks": decks, "page": page, "page size": pageSize, "total": countRow.total}});
+            });
+        });
+    }
+    sendBundles(user){
+        this.db.all("SELECT Bundle.bundleID, Bundle.name AS bundleName, Deck.deckID, Deck.name AS deckName FROM Bundle JOIN Bundle_Deck ON Bundle_Deck.bundleID = Bundle.bundleID JOIN Deck ON Deck.deckID = Bundle_Deck.deckID ORDER BY Bundle.name, Deck.name", (err, rows) => {
+            if(err) return console.log(`Error with get bundles SQL query: ${err}`);
+            let bundles = [];
+            rows.forEach((row) => { // there's a row for every deck in every bundle, so they're grouped back into bundles
+                let bundle = bundles.find(bundle => bundle.bundleID == row.bundleID);
+                if(!bundle){
+                    bundle = {"bundleID": row.bundleID, "name": row.bundleName, "decks": []};
+                    bundles.push(bundle);
+                }
+                bundle.decks.push({"deckID": row.deckID, "name": row.deckName});
+            });
+            user.returnMessage("update", true, {"bundles": bundles});
+        });
+    }
+    createBundle(user, name, deckIDs){
+        let container = this;
+        this.db.run("INSERT INTO Bundle (name) VALUES (?)", [name], function(err) {
+            if(err) return console.log(`Error creating bundle: ${err}`);
+            let bundleID = this.lastID;
+            let decksToGo = deckIDs.length;
+            deckIDs.forEach((deckID) => {
+                container.db.run("INSERT OR IGNORE INTO Bundle_Deck (bundleID, deckID) VALUES (?, ?)", [bundleID, deckID], (err) => {
+                    if(err) console.log(`Error adding deck to bundle: ${err}`);
+                    decksToGo--;
+                    if(decksToGo == 0) user.returnMessage("done", false, "Bundle Created!");
+                });
+            });
+        });
+    }
+    deleteBundle(user, bundleID){
+        this.db.serialize(() => {
+            this.db.run("DELETE FROM Bundle_Deck WHERE bundleID = ?", [bundleID]);
+            this.db.run("DELETE FROM Bundle WHERE bundleID = ?", [bundleID], (err) => {
+                if(err) return console.log(`Error deleting bundle: ${err}`);
+                user.returnMessage("done", false, "Bundle Deleted!");
+            });
+        });
+    }
+    previewDeck(user, deckID, count){
+        this.db.get("SELECT * FROM Deck WHERE deckID = ? AND ((public = true AND approved = true) OR userID = ?)", [deckID, user.userID], (err, deck) => {
+            if(err) return console.log(`Error with preview deck SQL query: ${err}`);
+            if(!deck) return user.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
+            let sample = "SELECT cardText, cardsToPick FROM Card WHERE deckID = ? AND cardType = ? ORDER BY RANDOM() LIMIT ?";
+            this.db.all(sample, [deckID, true, count], (err, whiteCards) => {
+                if(err) return console.log(`Error with preview deck SQL query: ${err}`);
+                this.db.all(sample, [deckID, false, count], (err, blackCards) => {
+                    if(err) return console.log(`Error with preview deck SQL query: ${err}`);
+                    user.returnMessage("update", true, {"deck preview": {
+                        "deckID": deck.deckID,
+                        "name": deck.name,
+                        "nsfw": !!deck.nsfw,
+                        "official": !!deck.official,
+                        "description": deck.description,
+                        "icon": deck.icon,
+                        "white cards": whiteCards.map(card => card.cardText),
+                        "black cards": blackCards.map(card => {
+                            return {"card text": card.cardText, "cards to pick": card.cardsToPick};
+                        })
+                    }});
+                });
+            });
+        });
+    }
+    rateDeck(user, deckID, rating){
+        this.db.get("SELECT userID FROM Deck WHERE deckID = ? AND ((public = true AND approved = true) OR userID = ?)", [deckID, user.userID], (err, row) => {
+            if(err) return console.log(`Error with rate deck SQL query: ${err}`);
+            if(!row) return user.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
+            if(row.userID == user.userID) return user.returnMessage("error", false, "You Can't Rate Your Own Deck!", "own deck");
+            this.db.run("INSERT OR REPLACE INTO Deck_Rating (userID, deckID, rating) VALUES (?, ?, ?)", [user.userID, deckID, rating], (err) => { // the primary key means each user only has one rating per deck, rating again replaces it
+                if(err) return console.log(`Error inserting deck rating: ${err}`);
+                user.returnMessage("done", false, "Rating Saved!");
+            });
+        });
+    }
+    favouriteDeck(user, deckID, favourite){
+        if(!favourite){ // this isn't checked when unfavouriting, so a deck that's been made private can still be taken off
+            return this.db.run("DELETE FROM Deck_Favourite WHERE userID = ? AND deckID = ?", [user.userID, deckID], (err) => {
+                if(err) return console.log(`Error updating deck favourite: ${err}`);
+                user.returnMessage("done", true, "deck unfavourited");
+            });
+        }
+        this.db.get("SELECT deckID FROM Deck WHERE deckID = ? AND ((public = true AND approved = true) OR userID = ?)", [deckID, user.userID], (err, row) => {
+            if(err) return console.log(`Error with favourite deck SQL query: ${err}`);
+            if(!row) return user.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
+            this.db.run("INSERT OR IGNORE INTO Deck_Favourite (userID, deckID) VALUES (?, ?)", [user.userID, deckID], (err) => {
+                if(err) return console.log(`Error updating deck favourite: ${err}`);
+                user.returnMessage("done", true, "deck favourited");
+            });
+        });
+    }
+    sendModerationQueue(user){ // this is the reports and the decks that have been published but not approved yet
+        this.db.all("SELECT Deck.deckID, Deck.name, User.username FROM Deck LEFT JOIN User ON User.userID = Deck.userID WHERE Deck.public = true AND Deck.approved = false", (err, rows) => {
+            if(err) return console.log(`Error with moderation queue SQL query: ${err}`);
+            let decks = rows.map(deck => {
+                return {"deckID": deck.deckID, "name": deck.name, "owner": deck.username};
+            });
+            user.returnMessage("update", true, {"moderation queue": {"reports": this.moderationQueue, "decks awaiting approval": decks}});
+        });
+    }
+    moderateDeck(user, deckID, approve){
+        let query = approve ? "UPDATE Deck SET approved = true WHERE deckID = ?" : "UPDATE Deck SET public = false, approved = false WHERE deckID = ?"; // removed decks are still there for their owner
+        this.db.run(query, [deckID], (err) => {
+            if(err) return console.log(`Error moderating deck: ${err}`);
+            this.moderationQueue = this.moderationQueue.filter(report => !(report.type == "deck" && report.deckID == deckID)); // the reports for the deck have been dealt with
+            user.returnMessage("done", false, approve ? "Deck Approved!" : "Deck Removed From Public Decks!");
+        });
+    }
+    recordCardStat(card, stat, amount = 1){ // stat is "played" or "won", no player information is stored so the stats stay anonymous
+        if(card.getOriginalID() < 0) return; // custom cards are only in one game, so there's no point keeping stats on them
+        if(stat != "played" && stat != "won") return console.log(`Unknown card stat: ${stat}`); // stat goes straight into the SQL so it has to be checked
+        this.db.run(`INSERT INTO Card_Stat (cardID, ${stat}) VALUES (?, ?) ON CONFLICT(cardID) DO UPDATE SET ${stat} = ${stat} + excluded.${stat}`, [card.getOriginalID(), amount], (err) => { // copies from infinite decks count for the card they were copied from
+            if(err) console.log(`Error recording card stat: ${err}`);
+        });
+    }
+    sendCardStats(user, deckID){ // this sends every white card in the deck with how often it has been played and won, least played first so the cards that never get picked are at the top
+        this.db.all("SELECT Card.cardID, Card.cardText, IFNULL(Card_Stat.played, 0) AS played, IFNULL(Card_Stat.won, 0) AS won FROM Card LEFT JOIN Card_Stat ON Card_Stat.cardID = Card.cardID WHERE Card.deckID = ? AND Card.cardType = true ORDER BY played, won", [deckID], (err, rows) => {
+            if(err) return console.log(`Error with get card stats SQL query: ${err}`);
+            if(rows.length == 0) return user.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
+            let cards = rows.map(row => {
+                return {"card id": row.cardID, "card text": row.cardText, "played": row.played, "won": row.won};
+            });
+            user.returnMessage("update", true, {"card stats": {"deckID": deckID, "cards": cards}});
+        });
+    }
+    sendPackStats(user){ // win rate is won/played so a deck with a few good cards isn't beaten by a big deck that gets played more
+        this.db.all("SELECT Deck.deckID, Deck.name, COUNT(Card.cardID) AS cards, IFNULL(SUM(Card_Stat.played), 0) AS played, IFNULL(SUM(Card_Stat.won), 0) AS won FROM Deck JOIN Card ON Card.deckID = Deck.deckID AND Card.cardType = true LEFT JOIN Card_Stat ON Card_Stat.cardID = Card.cardID GROUP BY Deck.deckID ORDER BY played DESC", (err, rows) => {
+            if(err) return console.log(`Error with get pack stats SQL query: ${err}`);
+            let packs = rows.map(row => {
+                return {"deckID": row.deckID, "name": row.name, "white cards": row.cards, "played": row.played, "won": row.won, "win rate": row.played > 0 ? row.won/row.played : 0};
+            });
+            user.returnMessage("update", true, {"pack stats": packs});
+        });
+    }
+    saveSnapshots(path){ // this is ran when the server is shutting down
+        let snapshots = this.games.list().map(game => game.getSnapshot());
+        fs.writeFileSync(path, JSON.stringify(snapshots));
+        console.log(`Saved ${snapshots.length} games to ${path}`);
+    }
+    loadSnapshots(path){
+        if(!fs.existsSync(path)) return;
+        try{
+            this.pausedGames = JSON.parse(fs.readFileSync(path));
+            fs.unlinkSync(path); // so the games aren't restored again after the next restart if no one comes back
+            console.log(`Loaded ${this.pausedGames.length} games from ${path}, waiting for their players to log in`);
+        } catch(e) {
+            console.log(`Error loading game snapshots: ${e}`);
+        }
+    }
+    restorePausedGame(user){ // this is ran when a user logs in, if they were in a game before the restart they're put back in it
+        let snapshot = this.pausedGames.find(snapshot => snapshot.players.find(player => player.username == user.username));
+        if(!snapshot || user.getGame()) return;
+        let game = snapshot.game; // this is set once the first player is back
+        if(game && this.games.get(game.gameName) != game) return; // the game has ended since
+        if(!game && this.games.get(snapshot["game name"])) return; // someone has made a new game with the same name
+        let score = snapshot.players.find(player => player.username == user.username).score;
+        snapshot.players = snapshot.players.filter(player => player.username != user.username);
+        if(snapshot.players.length == 0) this.pausedGames = this.pausedGames.filter(paused => paused != snapshot); // everyone is back
+        if(game){ // someone else from the game is already back
+            game.addPlayer(user);
+        } else {
+            game = new Game(user, this, snapshot["game name"], snapshot.password || undefined);
+            snapshot.game = game;
+            this.games.put(game);
+            this.gamesCreated++;
+            game.restoreSnapshot(snapshot, user);
+        }
+        game.players.find(player => player.user == user).score = score;
+        game.broadcastGameData();
+        this.sendGamesUpdate();
+    }
+    checkHeartbeats(){ // wifi dropping or a phone sleeping doesn't always close the websocket, this finds those connections
+        let now = Date.now();
+        this.disconnectedUsers.filter(user => now-user.disconnectedAt > resumeGracePeriod).forEach((user) => { // they didn't come back in time
+            this.disconnectedUsers = this.disconnectedUsers.filter(disconnected => disconnected != user);
+            user.ws.terminate(); // a connection that was closed for not responding might still be waiting on the other end, this makes sure the socket is gone
+            this.removeUser(user);
+        });
+        this.users.filter(user => !user.disconnectedAt).forEach((user) => {
+            if(now-user.lastPongAt > heartbeatTimeout){
+                user.log(`No pong for ${now-user.lastPongAt}ms, closing the connection`);
+                return user.ws.terminate(); // this runs the close event, which removes the user from their game
+            }
+            if(now-user.lastActiveAt > idleTimeout){
+                user.log(`Nothing sent for ${now-user.lastActiveAt}ms, closing the connection`);
+                return user.ws.close(closeCodes["idle timeout"], "idle for too long"); // they're disconnected like any other close, so their game carries on without them
+            }
+            if(user.ws.readyState == 1){
+                user.pingSentAt = now;
+                user.ws.ping();
+                user.sendPing();
+            }
+            user.catchUp(); // if they're not in a game that's sending updates, this is where they get caught up
+        });
+        this.games.list().forEach(game => game.sendLatencies()); // these are from the last pings, so they're a heartbeat behind
+    }
+    cleanUpGames(){ // this removes games that have been left running, it's ran every minute
+        let now = Date.now();
+        this.pausedGames = this.pausedGames.filter(snapshot => now-snapshot["saved at"] < maxGameInLobbyAge); // the players didn't come back in time
+        this.rateLimits.forEach((limits, ip) => { // IPs that haven't done anything for a while are forgotten
+            let recent = Object.keys(rateLimits).some(action => (limits[action] || []).some(time => now-time < rateLimitWindow) || limits["blocked until"][action] > now);
+            if(!recent) this.rateLimits.delete(ip);
+        });
+        this.games.list().forEach((game) => { // list() is a copy, so removeGame changing the store is fine
+            let connected = game.players.filter(player => player.user.ws.readyState == 1); // 1 is open
+            if(connected.length > 0){
+                game.noPlayersSince = null;
+            } else if(!game.noPlayersSince){
+                game.noPlayersSince = now;
+            }
+            let reason = "";
+            if(game.noPlayersSince && now-game.noPlayersSince > maxGameWithNoPlayersAge){
+                reason = "no players are connected";
+            } else if(["choosing white cards", "choosing winner"].includes(game.status) && now-game.lastActivityAt > maxGameInProgressAge){
+                reason = "no one has played for too long";
+            } else if(["setup", "finished"].includes(game.status) && now-game.lastActivityAt > maxGameInLobbyAge){
+                reason = "the game has been in the lobby for too long";
+            }
+            if(!reason) return;
+            console.log(`Removing game ${game.gameName}, ${reason}`);
+            connected.forEach(player => player.user.returnMessage("update", true, {"game expired": reason}));
+            this.removeGame(game);
+        });
+    }
     removeGame(game){ // this just removes the game that is passed
+        if(game.removed) return;
+        game.removed = true;
         game.players.forEach((player) => { // this sends a message "game ended" 
             player.user.returnMessage("update", true, "Game ended");
         });
         clearTimeout(game.nextRoundTimeout);
         console.log(`Game ended, name: ${game.gameName}`);
-        this.games = this.games.filter(value => value != game); // removes the game from the games array
+        this.games.delete(game); // removes the game from the game store
+        this.gamesRemoved++;
         this.sendGamesUpdate(); // sends the users the games information for the home screen
     }
     sendGamesUpdate(){
@@ -138,26 +694,39 @@ module.exports = class Container {
         
     } // not sure about this one
     userDisconnected(user){
-        
+        if(user.disconnectedAt || user.removed) return; // it's already being taken care of
+        if(!user.signedIn || !user.getGame()) return this.removeUser(user); // there's nothing to come back to
+        user.log(`${user.username} disconnected, waiting ${resumeGracePeriod/1000}s for them to come back`);
+        user.disconnectedAt = Date.now();
+        this.disconnectedUsers.push(user);
     }
-    getPublicDecks(){ // depreciated
-        this.db.all("SELECT * FROM Deck WHERE public = true", (err, rows) => {
-            if(err) return console.log(`Error with get decks SQL query: ${err}`);
-            rows;
-        });
+    moveConnection(user, newUser){ // the old user gets the new user's websocket
+        this.users = this.users.filter(value => value != newUser); // the new user was only made for the connection
+        ['message', 'close', 'ping', 'pong'].forEach(event => newUser.ws.removeAllListeners(event));
+        user.attachWebSocket(newUser.ws);
+        user.protocolVersion = newUser.protocolVersion;
+        user.disconnectedAt = null;
     }
-    updatePublicDecks(){ // Depreciated
-        this.db.all("SELECT * FROM Deck WHERE public = true", (err, rows) => {
-            if(err) return console.log(`Error with get decks SQL query: ${err}`);
-            this.publicDecks = [];
-            rows.forEach((deck) => {
-                this.db.all("SELECT * FROM Card WHERE deckID = ?", deck.deckID, (err, rows) => {
-                    if(err) return console.log(`Error with get decks SQL query: ${err}`);
-                    let whiteCardCount = rows.filter(card => card.cardType).length;
-                    let blackCardCount = rows.length-whiteCardCount;
-                    this.publicDecks.push({"name": deck.name, "deckID": deck.deckID, "white card count": whiteCardCount, "black card count": blackCardCount, "private": false});
-                });
-            });
-        });
+    takeOverSession(user, newUser){ // the user logged in again somewhere else, so the old connection is closed and they carry on with the new one
+        this.disconnectedUsers = this.disconnectedUsers.filter(disconnected => disconnected != user);
+        let oldWs = user.ws;
+        ['message', 'close', 'ping', 'pong'].forEach(event => oldWs.removeAllListeners(event)); // so closing it doesn't take them out of their game
+        if(oldWs.readyState == 1) oldWs.close(closeCodes["session taken over"], "logged in somewhere else");
+        this.moveConnection(user, newUser);
+        user.log(`${user.username} logged in on a new connection, the old one was closed`);
+        user.returnMessage("update", true, {"logged in": true, "games running": this.getGames(), "session token": user.sessionToken});
+        let game = user.getGame();
+        if(game) game.resync(game.players.find(player => player.user == user));
+    }
+    resumeSession(newUser, sessionToken, lastSequence){ // the old user gets the new websocket, so they're still in their game
+        let user = this.disconnectedUsers.find(user => user.sessionToken == sessionToken);
+        if(!user) return newUser.returnMessage("error", false, "Your Session Has Expired, Please Log In Again", "session expired");
+        this.disconnectedUsers = this.disconnectedUsers.filter(disconnected => disconnected != user);
+        this.moveConnection(user, newUser);
+        user.log(`${user.username} resumed their session`);
+        let game = user.getGame();
+        if(!user.resendMessagesAfter(lastSequence) && game){ // too much has been missed, so they get everything again
+            game.resync(game.players.find(player => player.user == user));
+        }
     }
 }
\ No newline at end of file
@@ -0,0 +1,26 @@
+const Deck = require('./deck.js');
+const Card = require('./card.js');
+
+module.exports = class CustomDeck extends Deck { // this holds the cards the host types in the lobby, they are only in this game and not in the database
+    constructor(game){
+        super("custom", game);
+    }
+    loadCards(){ // there's nothing to load, the cards are added with addCustomCard
+        this.name = "Custom Cards";
+    }
+    addCustomCard(type, text, cardsToPick, addedBy){
+        let card = new Card(this, this.game.nextCustomCardID--, type, text, cardsToPick); // custom card IDs are negative so they never match a card from the database
+        card.addedBy = addedBy; // this is the username of the player, so they can be limited and reported
+        type ? this.whiteCards.push(card) : this.blackCards.push(card);
+        return card;
+    }
+    getCardsAddedBy(username){
+        return this.whiteCards.concat(this.blackCards).filter(card => card.addedBy == username);
+    }
+    removeCustomCard(cardID){
+        let cardCount = this.whiteCards.length+this.blackCards.length;
+        this.whiteCards = this.whiteCards.filter(card => card.getID() != cardID);
+        this.blackCards = this.blackCards.filter(card => card.getID() != cardID);
+        return cardCount != this.whiteCards.length+this.blackCards.length; // returns if a card was removed
+    }
+}
\ No newline at end of file
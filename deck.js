@@ -5,12 +5,20 @@ module.exports = class Deck {
         this.game = game;
         this.deckID = deckID;
         this.name = "";
+        this.nsfw = false;
+        this.weight = 1; // how much more likely the cards in this deck are to be picked than the other decks in the game
         this.whiteCards = [];
         this.blackCards = [];
+        this.discardedWhiteCards = []; // cards that have been used go here, so they can go back in the deck if it runs out
+        this.discardedBlackCards = [];
+        this.loadCards();
+    }
+    loadCards(){ // this is seperate from the constructor so CustomDeck can not load from the database
         this.game.container.db.serialize(() => {
-            this.game.container.db.get("SELECT name FROM Deck WHERE deckID = ?", this.deckID, (err, row) => { // this just gets the deck name from the ID
+            this.game.container.db.get("SELECT name, nsfw FROM Deck WHERE deckID = ?", this.deckID, (err, row) => { // this just gets the deck name from the ID
                 if(err) return console.log(`Error with get deck name SQL query: ${err}`);
                 this.name = row.name; // *******************
+                this.nsfw = !!row.nsfw;
             });
             this.game.container.db.all("SELECT * FROM Card WHERE deckID = ?", [this.deckID], (err, rows) => { // this gets all the cards in the deck
                 if(err) return console.log(`Error with get cards SQL query: ${err}`);
@@ -25,39 +33,34 @@ module.exports = class Deck {
                 this.game.container.sendGamesUpdate(); // this gives the people waiting to join a game, on the games page an update on the deck thats been added
             })
         });
-        
     }
-    getCard(type, card){
-        if(type){ // is it black or white
-            if(card){ // has there been a card index given?
-                if(this.whiteCards[card]){ // is the index valid?
-                    this.whiteCards = this.whiteCards.filter(value => value.cardText != this.whiteCards[card]); // remove card from array
-                    return this.whiteCards[card]; // return card
-                } else {
-                    //return console.log(`Error getting white card, ${card} is not in the range of 0 to ${this.whiteCards.length}`);
-                    return false; // return false if the index is invalid
-                }
-            } else { // if no index is given, it chooses a random card, then removes it from the deck so it cant be drawn again
-                let cardChosen = Math.floor(Math.random() * this.whiteCards.length);
-                let cardToReturn = this.whiteCards[cardChosen];
-                this.whiteCards = this.whiteCards.filter(value => value.cardText != this.whiteCards[cardChosen]);
-                return cardToReturn;
-            }
+    getCard(type, card){ // the card is taken out of the deck so it can't be drawn again until the deck is reshuffled
+        let cards = type ? this.whiteCards : this.blackCards; // is it black or white
+        if(card === undefined) card = Math.floor(this.game.random() * cards.length); // if no index is given, it chooses a random card
+        if(!cards[card]){ // is the index valid?
+            //return console.log(`Error getting card, ${card} is not in the range of 0 to ${cards.length}`);
+            return false; // return false if the index is invalid
+        }
+        return cards.splice(card, 1)[0]; // remove card from array and return it
+    }
+    discard(card){ // for cards that have been played or thrown away
+        if(card.type){
+            this.discardedWhiteCards.push(card);
         } else {
-            if(card){
-                if(this.blackCards[card]){
-                    return this.blackCards[card];
-                } else {
-                    //return console.log(`Error getting black card, ${card} is not in the range of 0 to ${this.blackCards.length}`);
-                    return false;
-                }
-            } else {
-                let cardChosen = Math.floor(Math.random() * this.blackCards.length);
-                let cardToReturn = this.blackCards[cardChosen]; 
-                this.blackCards = this.blackCards.filter(value => value.cardText != this.blackCards[cardChosen]);
-                return cardToReturn;
-            }
+            this.discardedBlackCards.push(card);
+        }
+    }
+    reshuffle(type){ // puts the discarded cards back in the deck, it returns how many there were
+        let discarded = type ? this.discardedWhiteCards : this.discardedBlackCards;
+        let count = discarded.length;
+        if(type){
+            this.whiteCards = this.whiteCards.concat(discarded);
+            this.discardedWhiteCards = [];
+        } else {
+            this.blackCards = this.blackCards.concat(discarded);
+            this.discardedBlackCards = [];
         }
+        return count; // the cards are drawn at random, so they don't need to be mixed up
     }
     getCardByCardID(type, cardID){
         let card = type ? this.whiteCards.find(card => card.getID() == cardID) : this.blackCards.find(card => card.getID() == cardID); // card is set dependend on the type of the card
@@ -1,6 +1,9 @@
 const Deck = require('./deck.js');
+const CustomDeck = require('./customDeck.js');
 var _ = require('underscore');
 var striptags = require('striptags');
+const crypto = require('crypto');
+const closeCodes = require('./closeCodes.js');
 
 /*
 TODO: 
@@ -8,12 +11,96 @@ allow users websockets to disconnect and reconnect and be put into the same game
 
 */
 
+function seededRandom(seed){ // mulberry32, this works like Math.random but gives the same numbers every time for the same seed
+    return () => {
+        seed = (seed + 0x6D2B79F5) | 0;
+        let t = Math.imul(seed ^ (seed >>> 15), 1 | seed);
+        t = (t + Math.imul(t ^ (t >>> 7), 61 | t)) ^ t;
+        return ((t ^ (t >>> 14)) >>> 0) / 4294967296;
+    };
+}
+
+const maxDrawLogLength = 5000; // the oldest draws are dropped after this, so endless games don't fill up the memory
+const privateKeys = ["cards in hand", "your cards chosen"]; // only the player these belong to should see them, so messages with them can't be broadcast
+function containsPrivateData(content){
+    if(typeof content != "object" || content === null) return false;
+    return Object.keys(content).some(key => privateKeys.includes(key) || containsPrivateData(content[key]));
+}
+const lowCardRounds = 5; // the players are warned when there are about this many rounds of cards left
+const maxChatMessageLength = 200;
+const chatHistoryLength = 50; // this many chat messages are kept for players who reconnect
+const chatFloodLimit = 5; // each player can send this many chat messages every chatFloodWindow
+const chatFloodWindow = 10*1000;
+const reactionEmojis = ["laughing", "fire", "skull", "clapping", "grimacing", "sick"]; // the only reactions allowed, so there's nothing to moderate, the client shows the emoji for each
+const reactionBroadcastDelay = 500; // reactions are counted up for this long and sent together, instead of one message per tap
+
+// These choose who the next czar is, each takes the game and returns the user that should be the czar
+// to add a new one, add it here and it can be selected with the "change czar strategy" request
+const czarStrategies = {
+    "round robin": (game) => {
+        let index = game.players.findIndex(player => player.user == game.czar)+1; // gets the index of the czar
+        return index >= game.players.length ? game.players[0].user : game.players[index].user; // if the index of the old czar+1 is valid for a new index for the czar, use it, otherwise go back to index 0
+    },
+    "random": (game) => {
+        let candidates = game.players.length > 1 ? game.players.filter(player => player.user != game.czar) : game.players; // try not to pick the same czar twice in a row
+        return candidates[Math.floor(game.random() * candidates.length)].user;
+    },
+    "winner becomes czar": (game) => {
+        if(game.players.find(player => player.user == game.lastRoundWinner)) return game.lastRoundWinner;
+        return czarStrategies["round robin"](game); // if no one won the last round, or they've left, it falls back to round robin
+    }
+};
+
+// These are what the settings are when a game is created, setting a setting to null in "change settings" resets it to this
+const defaultSettings = {
+    "max cards in hand": 10,
+    "rounds": 10,
+    "win condition": "most points after max rounds",
+    "points to win": 7,
+    "czar strategy": "round robin",
+    "min players": 3,
+    "family friendly": false,
+    "deduplicate cards": true,
+    "infinite deck": false, // white cards go back in the deck after being drawn, for small decks
+    "public": true, // only public games are on the games page, the others can only be joined with the game name
+    "seed": null // if this is set, the cards are dealt in the same order every game, it's only shown to the host
+};
+const settingsRequests = ["change settings", "change max cards in hand", "change win condition", "change min players", "change czar strategy"]; // only the host can do these
+const moderationRequests = ["kick player", "mute player"]; // and these
+const deckRequests = ["add deck", "remove deck", "add bundle", "change deck weight"]; // and these
+// This is every status change that is allowed, setStatus refuses anything else
+const statusTransitions = {
+    "setup": ["choosing white cards", "finished"],
+    "choosing white cards": ["choosing winner", "finished"],
+    "choosing winner": ["choosing white cards", "finished"],
+    "finished": ["choosing white cards", "finished"]
+};
+// These are the statuses each game request can be used in, requests not listed here can be used at any time
+const requestStatuses = {
+    "start game": ["setup", "finished"],
+    "stop game": ["choosing white cards", "choosing winner"],
+    "submit cards": ["choosing white cards"],
+    "retract cards": ["choosing white cards"],
+    "choose winner": ["choosing winner"],
+    "vote": ["choosing winner"],
+    "rename": ["setup", "finished"],
+    "ready": ["setup", "finished"],
+    "add custom card": ["setup", "finished"],
+    "remove custom card": ["setup", "finished"],
+    "report card": ["choosing winner"],
+    "react": ["choosing winner"],
+    "reading": ["choosing winner"],
+    "pick winner": ["choosing winner"]
+};
+
 module.exports = class Game {
     constructor(host, container, name, password){
         // *********** initialising the attributes ***********
         this.container = container;
         this.gameName = name;
-        this.status = "setup"; // Statuses: setup, choosing white cards, choosing winner, finished
+        this.status = "setup"; // Statuses: setup, choosing white cards, choosing winner, finished, this should only be changed with setStatus
+        this.statusChangeHooks = []; // functions that are ran with (from, to) whenever the status changes
+        this.events = []; // every change to the game is added to the end of this, it is never edited so the game can be replayed from it
         this.round = 0;
         this.rounds = 10;
         this.host = {};
@@ -34,6 +121,39 @@ module.exports = class Game {
         this.nextRoundTimeout = function () {};
         this.maxCardsInHand = 10;
         this.joinable = true;
+        this.createdAt = Date.now();
+        this.joinCode = container.makeJoinCode(); // this is only sent to the players, so private games stay private
+        this.removed = false; // set by the container when the game is removed, so it's only removed once
+        this.winCondition = "most points after max rounds"; // Win conditions: first to points, most points after max rounds, endless
+        this.pointsToWin = 7; // only used when the win condition is "first to points"
+        this.czarStrategy = "round robin"; // this is a key in czarStrategies
+        this.minPlayers = 3; // the host can lower this to 2, in which case rounds with 2 players are voted on instead of having a czar
+        this.votes = new Map(); // user => player they voted for, only used in voting rounds
+        this.votingRound = false; // set at the start of each round, see isVotingRound
+        this.familyFriendly = false; // when this is on, only decks that aren't NSFW can be added
+        this.deduplicateCards = true; // when this is on, cards that are in more than one deck are only in the game once
+        this.infiniteDeck = false;
+        this.copiesDrawn = 0; // the infinite deck numbers the copies it gives out
+        this.public = true;
+        this.seed = null;
+        this.lowCardWarnings = {"white": false, "black": false}; // so each warning is only sent once until the cards go back up
+        this.random = Math.random; // this is swapped for a seeded one when the game starts if there is a seed
+        this.settingsVersion = 0; // goes up by one every time the settings change, so edits based on old settings can be refused
+        this.lastRoundWinner = {};
+        this.czarNote = ""; // the czar can say something about the winning cards, like reading them out at the table
+        this.roundHistory = []; // the black card, winner and winning cards of every round this game
+        this.chatHistory = []; // the last few chat messages, sent again when someone resyncs
+        this.reactions = new Map(); // emoji => the usernames who reacted with it, to the winning card this round
+        this.reactionTimeout = null;
+        this.drawLog = []; // every card dealt this game and who to, only the host and admins can see it
+        this.startedAt = null;
+        this.sequence = 0; // this goes up by one every broadcast, if a client sees it go up by more than one it missed an update and should ask for a resync
+        this.lastActivityAt = Date.now(); // this and noPlayersSince are for the container removing old games
+        this.noPlayersSince = null;
+        this.summary = null; // this is made when the game finishes, for the results screen
+        this.assembledDeck = null; // the cards the last game started with, so the host can export them
+        this.customDeck = null; // this is made when the host adds their first custom card
+        this.nextCustomCardID = -1;
         
         if(password){ // if there is a password passed, the game is private
             if(password.length > 3 && password.length < 30){ // if the password is the right length
@@ -47,27 +167,49 @@ module.exports = class Game {
             this.private = false;
             this.password = "";
         }
+        this.statusChangeHooks.push((from, to) => this.recordEvent("status changed", {"from": from, "to": to}));
+        this.statusChangeHooks.push((from, to) => {
+            if(to == "choosing white cards" && (from == "setup" || from == "finished")) this.startedAt = Date.now();
+        });
         this.setHost(host);
         this.addPlayer(host);
+        this.skipDefaultDeck = false; // the default deck isn't added if the game was made with a bundle or restored from a snapshot
+        this.container.getDefaultDeck((deckID) => {
+            if(deckID && this.decks.length == 0 && !this.skipDefaultDeck) this.addDeck(deckID, this.host); // the host might have added a deck already
+        });
         //setTimeout(() => this.host.returnMessage("update", true, {"decks available": this.container.publicDecks.concat(this.host.privateDecks)}), 150);
     }
     startGame(){
-        // this makes sure there are enough black question cards for the game
+        if(this.deduplicateCards) this.removeDuplicateCards(); // this is done before the card counts are checked, as it can lower them
+        this.random = this.seed === null ? Math.random : seededRandom(this.seed); // it's seeded again every game so each one is the same
         let blackCards = 0;
-        this.getDecksAdded().forEach(deck => blackCards += deck["black card count"]);
-        if(blackCards < this.rounds) return this.host.returnMessage("error", false, "There are not enough black cards for the amount of rounds!");
-
-        // this makes sure there are enough white answer cards for the game, if there are too few for the rounds and players, considering the "maxCardsInHand", it will not run
         let whiteCards = 0;
-        this.getDecksAdded().forEach(deck => whiteCards += deck["white card count"]);
-        if(whiteCards/(this.players.length) < this.maxCardsInHand+(this.rounds*this.players.length)) return this.host.returnMessage("error", true, "There are not enough white cards for players and rounds!");
+        this.getDecksAdded().forEach((deck) => {
+            blackCards += deck["black card count"];
+            whiteCards += deck["white card count"];
+        });
+        // whatever the win condition is, there has to be a black card for the first round and enough white cards to give everyone a hand
+        if(blackCards == 0) return this.host.returnMessage("error", false, "There Are No Black Cards In The Decks!", "not enough cards");
+        if(whiteCards == 0 || (!this.infiniteDeck && whiteCards < this.maxCardsInHand*this.players.length)) return this.host.returnMessage("error", false, "There Are Not Enough White Cards To Give Everyone A Hand!", "not enough cards");
+        if(this.winCondition == "most points after max rounds"){ // the other win conditions don't have a set amount of rounds, so there's no way to know how many cards the whole game needs
+            // this makes sure there are enough black question cards for the game
+            if(blackCards < this.rounds) return this.host.returnMessage("error", false, "There are not enough black cards for the amount of rounds!", "not enough cards");
+
+            // this makes sure there are enough white answer cards for the game, if there are too few for the rounds and players, considering the "maxCardsInHand", it will not run
+            if(!this.infiniteDeck && whiteCards/(this.players.length) < this.maxCardsInHand+(this.rounds*this.players.length)) return this.host.returnMessage("error", true, "There are not enough white cards for players and rounds!", "not enough cards");
+        }
 
         // this sets the status so the clients and the game running can work properly
-        this.status = "choosing white cards";
+        this.votingRound = this.shouldVote();
+        if(!this.setStatus("choosing white cards")) return;
+        this.assembledDeck = this.assembleDeck(); // this is before any cards are drawn
+        this.roundHistory = []; // the history from the last game is cleared
+        this.drawLog = [];
         // this sets the stage ending time and allows for the timer to work and the clients know when the round is over then
         this.stageEndingTime = Date.now()+this.roundTimes[this.status];
         // this gets the black card that the players pick the answers to
         this.blackCard = this.getCard(false);
+        this.logDraw(null, this.blackCard);
         // resets all of the players cards if there was a game before
         
 
@@ -75,6 +217,11 @@ module.exports = class Game {
         this.players.forEach((player) => {
             this.giveCards(player);
         });
+        this.lowCardWarnings = {"white": false, "black": false};
+        this.checkCardsRemaining();
+        // everyone is told the black card and czar, then each player gets their own hand, and only theirs
+        this.broadcast("update", true, {"game started": this.getRoundStart()}, this.players, true);
+        this.players.forEach(player => this.sendToPlayer(player, "update", true, {"cards in hand": this.getCardsInHand(player)}));
         // this sends the new game information out to the players
         this.broadcastGameData();
         // finally, this is the timer to go to the next stage, (choosing winner)
@@ -83,35 +230,48 @@ module.exports = class Game {
         }, this.stageEndingTime-Date.now()); // the stageEndingTime is used to determine how long the timeout is
     }
     goToNextStage(){
+        if(this.removed) return; // a timeout that was already running when the game was removed
         clearTimeout(this.nextRoundTimeout); // Clears any timeout to run it again, if it has been run early and not by the timeout
         
         if(this.status == "setup"){
             this.startGame();
         } else if(this.status == "choosing white cards"){ // end choosing white card stage
-            this.status = "choosing winner"; // this sets the status so if there is a request to choose the winning card, it allows it
+            this.setStatus("choosing winner"); // this sets the status so if there is a request to choose the winning card, it allows it
             this.stageEndingTime = Date.now()+this.roundTimes["choosing winner"]; // so the user and the game knows when this stage ends
             this.broadcastGameData();
+            if(!this.isVotingRound()) this.sendToCzar("update", true, {"pick a winner": true}); // so the czar's client can get their attention
             this.nextRoundTimeout = setTimeout(() => { // sets the time out
                 this.goToNextStage();
             }, this.stageEndingTime - Date.now());
         } else if(this.status == "choosing winner"){
-            if(this.round < this.rounds){ // checks to see if there are any more rounds to play
-                if(!this.winner.ws){ // if the czar didnt pick the winner, remove them for being AFK, prob should change
+            if(this.isVotingRound() && !this.winner.ws && this.votes.size > 0) return this.tallyVotes(); // if the time runs out, the votes that are in get counted
+            if(!this.hasWinConditionBeenMet()){ // checks to see if there are any more rounds to play
+                if(this.isVotingRound()){ // no one is removed in a voting round, as there's no czar to be AFK
+                    this.winner = {};
+                    this.czarNote = "";
+                } else if(!this.winner.ws){ // if the czar didnt pick the winner, remove them for being AFK, prob should change
                     let czarPlayer = this.players.find(player => player.user == this.czar);
                     this.removePlayer(czarPlayer);
                 } else {
                     this.winner = {};
+                    this.czarNote = "";
                 }
-                this.status = "choosing white cards";
+                this.votingRound = this.shouldVote();
+                this.setStatus("choosing white cards");
                 this.round ++;
                 this.players.forEach((player) => {
                     /*player["cards chosen"].forEach(() => {
                         player["cards in hand"].push(this.getCard(true)); // gives a new card for every card used
                     });*/
                     this.giveCards(player);
+                    this.discardCards(player["cards chosen"]);
                     player["cards chosen"] = []; // clears the cards chosen array for the player
                 });
+                this.votes.clear();
+                this.discardCards([this.blackCard]);
                 this.blackCard = this.getCard(false); // sets the new black card
+                this.logDraw(null, this.blackCard);
+                this.checkCardsRemaining();
                 this.changeCzar();
                 this.stageEndingTime = Date.now()+this.roundTimes["choosing white cards"]+(this.roundTimes["choosing white cards multiplier"]*this.blackCard.cardsToPick);
                 this.nextRoundTimeout = setTimeout(() => { // sets the time out
@@ -125,12 +285,125 @@ module.exports = class Game {
             this.startGame();
         }
     }
+    recordEvent(type, details){ // types: join, leave, play, judge, settings changed, status changed
+        this.events.push(Object.assign({"type": type, "time": Date.now()}, details));
+    }
+    setStatus(newStatus){ // all status changes go through here so they can be checked against statusTransitions
+        let oldStatus = this.status;
+        if(!statusTransitions[oldStatus].includes(newStatus)){
+            console.log(`invalid status change in ${this.gameName}, from ${oldStatus} to ${newStatus}`);
+            return false;
+        }
+        this.status = newStatus;
+        this.statusChangeHooks.forEach(hook => hook(oldStatus, newStatus));
+        this.broadcast("update", true, {"status changed": {"from": oldStatus, "to": newStatus}}, this.players, true); // a new round starting is a status change, so they have to be acked
+        return true;
+    }
+    shouldVote(){ // with only 2 players a czar game doesn't work, so everyone plays and then votes for someone elses cards
+        return this.minPlayers < 3 && this.players.length < 3;
+    }
+    isVotingRound(){ // this is decided when each round starts, so someone joining or leaving halfway through doesn't change it
+        return ["choosing white cards", "choosing winner"].includes(this.status) ? this.votingRound : this.shouldVote();
+    }
+    tallyVotes(){
+        let voteCounts = new Map();
+        this.votes.forEach(player => voteCounts.set(player, (voteCounts.get(player) || 0)+1));
+        let mostVotes = Math.max(...voteCounts.values());
+        let topPlayers = [...voteCounts.keys()].filter(player => voteCounts.get(player) == mostVotes);
+        this.chooseWinner(topPlayers[Math.floor(this.random() * topPlayers.length)]); // ties are decided randomly
+    }
+    assembleDeck(){ // this is in the same format as "add new deck", so an exported deck can be uploaded again
+        let assembled = {"decks": [], "white cards": [], "black cards": []};
+        this.decks.forEach((deck) => {
+            assembled.decks.push({"id": deck.deckID, "name": deck.getDeckName()});
+            deck.whiteCards.forEach(card => assembled["white cards"].push(card.getCardText()));
+            deck.blackCards.forEach(card => assembled["black cards"].push({"cardText": card.getCardText(), "cardsToPick": card.cardsToPick}));
+        });
+        return assembled;
+    }
+    removeDuplicateCards(){ // if a card is in more than one deck, it's only kept in the first deck it was found in
+        let normalise = card => card.getCardText().toLowerCase().replace(/\s+/g, " ").replace(/[.!?]+$/, "").trim(); // so "A fish." and "a fish" are the same
+        [true, false].forEach((type) => { // white then black cards
+            let seen = new Set();
+            this.decks.forEach((deck) => {
+                let cards = type ? deck.whiteCards : deck.blackCards;
+                let unique = cards.filter((card) => {
+                    let text = normalise(card);
+                    if(seen.has(text)) return false;
+                    seen.add(text);
+                    return true;
+                });
+                type ? deck.whiteCards = unique : deck.blackCards = unique;
+            });
+        });
+    }
+    hasWinConditionBeenMet(){ // this is checked at the end of every round to see if the game should finish
+        if(this.winCondition == "first to points"){
+            return this.players.some(player => player.score >= this.pointsToWin);
+        } else if(this.winCondition == "endless"){
+            return false; // endless games only finish when the host stops them
+        } else { // most points after max rounds
+            return this.round >= this.rounds;
+        }
+    }
+    getCardsRemaining(){ // how many cards are left to draw, not counting the discard piles
+        let remaining = {"white": 0, "black": 0};
+        this.decks.forEach((deck) => {
+            remaining.white += deck.getCardCount(true);
+            remaining.black += deck.getCardCount(false);
+        });
+        return remaining;
+    }
+    checkCardsRemaining(){ // each player draws about one white card a round, so the white limit goes up with the players
+        let remaining = this.getCardsRemaining();
+        let limits = {"white": this.players.length*lowCardRounds, "black": lowCardRounds};
+        Object.keys(limits).forEach((type) => {
+            if(remaining[type] >= limits[type]) return this.lowCardWarnings[type] = false; // there are enough again, like after a reshuffle
+            if(this.lowCardWarnings[type]) return;
+            this.lowCardWarnings[type] = true;
+            this.recordEvent("low on cards", {"card type": type, "remaining": remaining[type]});
+            this.broadcast("update", true, {"low on cards": {"card type": type, "remaining": remaining[type]}});
+        });
+    }
+    reshuffleDiscards(type){
+        let count = 0;
+        this.decks.forEach(deck => count += deck.reshuffle(type));
+        if(count == 0) return; // nothing has been played yet, so there's nothing to put back
+        let cardType = type ? "white" : "black";
+        this.recordEvent("deck reshuffled", {"card type": cardType, "cards": count});
+        this.broadcast("update", true, {"deck reshuffled": {"card type": cardType, "cards": count}});
+    }
+    discardCards(cards){
+        cards.forEach((card) => {
+            if(this.infiniteDeck && card && card.type) return; // the white cards never left the deck
+            if(card && card.deck) card.deck.discard(card);
+        });
+    }
+    logDraw(player, card){ // player is null for black cards
+        if(!card) return;
+        this.drawLog.push({"time": Date.now(), "round": this.round, "player": player ? player.user.username : null, "card id": card.getID(), "card text": card.getCardText(), "white card": !!card.type});
+        if(this.drawLog.length > maxDrawLogLength) this.drawLog.shift();
+    }
+    drawWhiteCard(player){
+        if(!this.infiniteDeck) return this.getCard(true);
+        let card;
+        for(let attempt = 0; attempt < 10; attempt++){ // a few goes at finding a card that isn't in their hand already, it gives up for really small decks
+            card = this.getCard(true);
+            if(!card) return card;
+            card.deck.whiteCards.push(card); // it's put straight back so it can be drawn again
+            if(!player["cards in hand"].some(held => held.original == card)) break;
+        }
+        return card.copy(++this.copiesDrawn); // the player gets a copy, so if someone else draws the same card the IDs are still different
+    }
     giveCards(player){ // this gives new cards to make sure that the player always has the "maxCardsInHand" amount of cards
         if(player["cards in hand"].length > this.maxCardsInHand){ // if the player has too many cards
+            this.discardCards(player["cards in hand"].slice(this.maxCardsInHand));
             player["cards in hand"] = player["cards in hand"].slice(0, this.maxCardsInHand); // "slice" the array down to the amount they should have
         } else {
             for(var i = player["cards in hand"].length; i < this.maxCardsInHand; i++){ // because i is set to the cards in hand length and it goes up to the maxCardsInHand so they will always have the right amount
-                player["cards in hand"].push(this.getCard(true));
+                let card = this.drawWhiteCard(player);
+                this.logDraw(player, card);
+                player["cards in hand"].push(card);
             }
         }
     }
@@ -143,22 +416,24 @@ module.exports = class Game {
         it's been through to the cards count until it gets to the card that it's
         looking for
 
+        Each deck's cards are counted "weight" times, so a deck with a weight
+        of 3 is 3 times as likely to have each of its cards picked
+
         */
         if(this.decks.length == 0) return console.log("can't get a card when there are no decks"); // this console.log is to for debugging, it shouldn't appear and is a server side error as it should have been checked
+        if(this.decks.every(deck => deck.getCardCount(type) == 0)) this.reshuffleDiscards(type); // the deck has run out
         var total = 0;
-        var lengths = [];
         this.decks.forEach((deck) => {
-            let length = deck.getCardCount(type);
-            lengths.push(length);
-            total += length;
+            total += deck.getCardCount(type)*deck.weight;
         });
-        var randCard = Math.floor(Math.random() * total);
+        var randCard = Math.floor(this.random() * total);
         var cards = 0;
         for(var i = 0; i < this.decks.length; i++){ // for every deck until it returns
-            if(cards+this.decks[i].getCardCount(type) > randCard){ // sees if the card is in that deck
-                return this.decks[i].getCard(type, randCard-cards); // if it is the right card it returns it
+            let weightedCount = this.decks[i].getCardCount(type)*this.decks[i].weight;
+            if(cards+weightedCount > randCard){ // sees if the card is in that deck
+                return this.decks[i].getCard(type, Math.floor((randCard-cards)/this.decks[i].weight)); // if it is the right card it returns it
             } else {
-                cards += this.decks[i].getCardCount(type); // adds deck length to cards to check
+                cards += weightedCount; // adds deck length to cards to check
             }
         }
         // it shouldn't ever get to here, but if it does, theres a console log to tell me and help debug
@@ -182,11 +457,15 @@ module.exports = class Game {
     }
     addPlayer(user){
         user.inGame = true;
+        this.lastActivityAt = Date.now();
         let playerObject = { // the player object contains the player information
             "user": user, // pointer to the user instance
             "score": 0,
             "cards in hand": [],
             "cards chosen": [],
+            "ready": false, // the game can't be started until everyone is ready, unless the host forces it
+            "muted": false, // the host can stop a player from chatting
+            "chat times": [], // when they sent their recent chat messages, for stopping them flooding the chat
             "lastDataSent": {game:{}} // this is to remember what data needs to be sent to the client to keep them updated
         };
         if(this.status == "choosing white cards" || this.status == "choosing winner"){ // if the game is running, give them cards
@@ -195,13 +474,50 @@ module.exports = class Game {
         this.players.push(playerObject); // adds them to the players array
         this.container.sendGamesUpdate(); // tells everyone on the home/games screen that there's a new player
         this.broadcastGameData(); // tells the other users that there's a new player
-        console.log(`${user.username} joined game ${this.gameName}`); // for debugging, logs the player joining to the console
+        this.recordEvent("join", {"username": user.username});
+        this.sendPresence(user, "joined");
+        user.log(`${user.username} joined game ${this.gameName}`); // for debugging, logs the player joining to the console
         //this.status = "setup"; // Statuses: setup, choosing white cards, choosing winner, finished
         // ^^ this adds to the array players, then sends the game data to the user, with the returned value
     }
+    kickPlayer(player, reason){ // like leaving, but the player is told why and their connection is closed
+        this.sendToPlayer(player, "update", true, {"kicked": reason});
+        this.recordEvent("kick", {"username": player.user.username, "reason": reason});
+        this.broadcast("update", true, {"player kicked": {"username": player.user.username, "reason": reason}}, this.players.filter(other => other != player));
+        if(this.players.length < 2){
+            this.container.removeGame(this);
+        } else {
+            let wasCzar = player.user == this.czar;
+            if(player.user == this.host) this.setHost(this.players.find(other => other != player).user);
+            this.removePlayer(player);
+            if(wasCzar && this.players.length > 0) this.changeCzar();
+        }
+        if(player.user.ws.readyState == 1) player.user.ws.close(closeCodes["kicked"], "kicked"); // they're not in a game now, so the container removes them when it closes
+    }
+    getAdminState(){ // everything about the game for admins looking into problems, this is never sent to players
+        return {
+            "game name": this.gameName,
+            "join code": this.joinCode,
+            "status": this.status,
+            "round": this.round,
+            "host": this.host.username,
+            "czar": this.czar.username,
+            "created at": this.createdAt,
+            "started at": this.startedAt,
+            "settings": this.getSettings(),
+            "decks added": this.getDecksAdded(),
+            "cards remaining": this.getCardsRemaining(),
+            "players": this.players.map(player => {
+                return {"username": player.user.username, "score": player.score, "cards in hand": player["cards in hand"].length, "cards chosen": player["cards chosen"].length, "ready": player.ready, "connected": player.user.ws.readyState == 1, "ip": player.user.ip};
+            }),
+            "events": this.events.slice(-50) // the last few, the whole list can get long
+        };
+    }
     removePlayer(player){ // should probably make this remove user
         if(!player) return;
-        console.log(`Player Removed from ${this.gameName}, username: ${player.user.username}`);
+        player.user.log(`Player Removed from ${this.gameName}, username: ${player.user.username}`);
+        this.recordEvent("leave", {"username": player.user.username});
+        this.sendPresence(player.user, "left");
         player.user.inGame = false;
         player.user.returnMessage("update", true, {"left game": true, "games running": this.container.getGames()}); // tells the player that they've left the game and the games running currently for the games page they'll be going to
         this.players = this.players.filter(value => value != player); // removes player from array
@@ -213,49 +529,191 @@ module.exports = class Game {
     }
     sendMessage(user, message){ // this sends the message to everyone in the game
         message = striptags(message);
-        for(var i = 0; i < this.players.length; i++) {
-            this.players[i].user.returnMessage("message", "true", {"from": user.username, "contents": message});
-        }
+        this.chatHistory.push({"from": user.username, "contents": message, "sent at": Date.now()});
+        if(this.chatHistory.length > chatHistoryLength) this.chatHistory.shift();
+        this.broadcastNotification("chat", "message", "true", {"from": user.username, "contents": message});
         return true;
     }
+    renamePlayer(user, username){
+        if(username.length < 3 || username.length > 20) return user.returnMessage("error", true, "invalid username length", "invalid username");
+        let nameError = this.container.checkName(username);
+        if(nameError) return user.returnMessage("error", false, nameError, "username not allowed");
+        // usernames have to be unique, both for the people online and the registered users, the same as when signing up
+        if(this.container.users.find(other => other != user && other.username.toLowerCase() == username.toLowerCase())) return user.returnMessage("error", false, "That Username Is Already In Use!", "username taken");
+        this.container.db.get("SELECT userID FROM User WHERE username = ? COLLATE NOCASE AND userID != ?", [username, user.userID], (err, row) => {
+            if(err) return console.log(`Error with rename SQL query: ${err}`);
+            if(row) return user.returnMessage("error", false, "That Username Is Already In Use!", "username taken");
+            if(user.userID != -1){ // registered users keep their new name
+                this.container.db.run("UPDATE User SET username = ? WHERE userID = ?", [username, user.userID], (err) => {
+                    if(err) console.log(`Error updating username in rename: ${err}`);
+                });
+            }
+            this.recordEvent("rename", {"from": user.username, "to": username});
+            user.username = username;
+            user.returnMessage("update", true, {"username": username});
+            this.container.sendGamesUpdate(); // the host's name is on the games page
+            this.broadcastGameData();
+        });
+    }
+    queueReactions(){ // the counts are sent a moment later, so lots of reactions at once are one message
+        if(this.reactionTimeout) return;
+        this.reactionTimeout = setTimeout(() => {
+            this.reactionTimeout = null;
+            if(this.removed) return;
+            let counts = {};
+            this.reactions.forEach((usernames, emoji) => {
+                if(usernames.size > 0) counts[emoji] = usernames.size;
+            });
+            this.broadcastNotification("reactions", "update", true, {"reactions": counts});
+        }, reactionBroadcastDelay);
+    }
+    sendLatencies(){ // so the czar can see if someone is lagging rather than taking ages
+        this.broadcastNotification("presence", "update", true, {"latency": this.players.map(player => {
+            return {"username": player.user.username, "latency": player.user.disconnectedAt ? null : player.user.latency};
+        })});
+    }
+    sendPresence(user, change){ // tells the other players when someone joins or leaves, change is "joined" or "left"
+        this.broadcastNotification("presence", "update", true, {"presence": {"username": user.username, "change": change}}, this.players.filter(player => player.user != user));
+    }
     incomingRequest(user, data){ // this handles the requests from the players
         if(!data.request) return user.returnMessage("error", true, "no request");
+        this.lastActivityAt = Date.now(); // anything a player does keeps the game from being removed
+        if(requestStatuses.hasOwnProperty(data.request) && !requestStatuses[data.request].includes(this.status)) return user.returnMessage("error", true, `invalid request, cannot ${data.request} while the game is ${this.status}`, "wrong game status");
         if(data.request == "message"){
-            if(!data.content) return user.returnMessage("error", true, "no message to send!");
+            if(typeof data.content != "string" || !data.content.trim()) return user.returnMessage("error", true, "no message to send!", "empty message");
             data.content = data.content.trim(); // trimmming the message so the spaces at the start/end are removed
+            if(data.content.length > maxChatMessageLength) return user.returnMessage("error", false, `Your Message Is Too Long, It Has To Be ${maxChatMessageLength} Characters Or Less!`, "message too long");
+            let player = this.players.find(player => player.user == user);
+            if(player.muted) return user.returnMessage("error", false, "The Host Has Muted You!", "muted");
+            let now = Date.now();
+            player["chat times"] = player["chat times"].filter(time => now-time < chatFloodWindow);
+            if(player["chat times"].length >= chatFloodLimit && !this.container.sandbox) return user.returnMessage("error", false, "Slow Down! You're Sending Messages Too Quickly", "rate limited");
+            player["chat times"].push(now);
             this.sendMessage(user, data.content);
             return user.returnMessage("done", true, "message sent");
         }   
+        if(data.request == "get settings"){
+            let settings = this.getSettings();
+            if(user != this.host) delete settings["seed"]; // the players could work out what cards are coming with the seed
+            return user.returnMessage("update", true, {"settings": settings, "settings version": this.settingsVersion});
+        }
+        if(data.request == "draw log"){ // this is for checking "I never had that card", so players can't see other peoples hands
+            if(user != this.host && !user.admin) return user.returnMessage("error", true, "invalid request, only the host can see the draw log", "not host");
+            return user.returnMessage("update", true, {"draw log": this.drawLog});
+        }
+        if(data.request == "get summary"){
+            if(!this.summary) return user.returnMessage("error", true, "invalid request, no game has finished yet");
+            return user.returnMessage("update", true, {"summary": this.summary});
+        }
+        if(data.request == "add custom card"){ // everyone can add a few custom cards in the lobby
+            if(typeof data.text != "string") return user.returnMessage("error", true, "invalid request, no card text");
+            if(typeof data.whiteCard != "boolean") return user.returnMessage("error", true, "invalid request, whiteCard should be true or false");
+            return this.addCustomCard(user, data.whiteCard, striptags(data.text).trim(), data.cardsToPick);
+        }
+        if(data.request == "remove custom card"){ // the host can remove any custom card, everyone else can only remove their own
+            let card = this.customDeck ? this.customDeck.getCardByCardID(true, data.cardID) || this.customDeck.getCardByCardID(false, data.cardID) : false;
+            if(!card) return user.returnMessage("error", true, "invalid request, custom card not added");
+            if(user != this.host && card.addedBy != user.username) return user.returnMessage("error", false, "You Can Only Remove Your Own Custom Cards!", "not card owner");
+            this.customDeck.removeCustomCard(data.cardID);
+            return this.broadcastGameData();
+        }
+        if(data.request == "react"){ // a reaction to the winning card, sending the same one again takes it back
+            if(!this.winner.ws) return user.returnMessage("error", true, "invalid request, the winner hasn't been chosen yet");
+            if(!reactionEmojis.includes(data.emoji)) return user.returnMessage("error", true, "invalid request, that isn't one of the reactions");
+            if(!this.reactions.has(data.emoji)) this.reactions.set(data.emoji, new Set());
+            let usernames = this.reactions.get(data.emoji);
+            usernames.has(user.username) ? usernames.delete(user.username) : usernames.add(user.username);
+            return this.queueReactions();
+        }
+        if(data.request == "resync"){
+            if(data.reason == "checksum mismatch") this.container.checksumMismatches++; // this shouldn't happen, so it's counted to find bugs in the updates
+            return this.resync(this.players.find(player => player.user == user));
+        }
+        if(data.request == "ready"){
+            let player = this.players.find(player => player.user == user);
+            player.ready = typeof data.ready == "boolean" ? data.ready : !player.ready; // if no value is sent, it toggles
+            return this.broadcastGameData();
+        }
+        if(data.request == "rename"){ // anyone can change their name while in the lobby
+            if(typeof data.username != "string") return user.returnMessage("error", true, "invalid request, no username given", "invalid username");
+            return this.renamePlayer(user, data.username.trim());
+        }
+        if(user != this.host && settingsRequests.includes(data.request)) return user.returnMessage("error", true, "invalid request, only the host can change the settings", "not host");
+        if(user != this.host && moderationRequests.includes(data.request)) return user.returnMessage("error", true, `invalid request, only the host can ${data.request.split(" ")[0]} players`, "not host");
+        if(user != this.host && deckRequests.includes(data.request)) return user.returnMessage("error", true, "invalid request, only the host can change the decks", "not host");
         if(user == this.host){
-            if(data.request == "change max cards in hand"){
+            if(data.request == "change settings"){ // settings is a JSON merge patch, only the settings that are changing need to be sent
+                if(!Number.isInteger(data.version)) return user.returnMessage("error", true, "invalid request, no settings version given");
+                if(data.version != this.settingsVersion) return user.returnMessage("error", false, "The Settings Have Been Changed Since You Opened Them, Please Try Again!", "settings changed"); // stops two tabs overwriting each others changes
+                return this.changeSettings(user, data.settings);
+            } else if(data.request == "change max cards in hand"){
                 if(!data.maxCards) return user.returnMessage("error", true, "no max cards provided");
-                if(data.maxCards <= 40 && data.maxCards >= 5){
-                    return this.updateMaxCardsInHand(data.maxCards);
-                } else {
-                    return user.returnMessage("error", true, "max cards invalid range");
+                return this.changeSettings(user, {"max cards in hand": data.maxCards});
+            } else if(data.request == "change win condition"){
+                let settings = {"win condition": data.winCondition};
+                if(data.pointsToWin) settings["points to win"] = data.pointsToWin;
+                return this.changeSettings(user, settings);
+            } else if(data.request == "change min players"){
+                if(!data.minPlayers) return user.returnMessage("error", true, "no min players provided");
+                return this.changeSettings(user, {"min players": data.minPlayers});
+            } else if(data.request == "change czar strategy"){
+                return this.changeSettings(user, {"czar strategy": data.czarStrategy});
+            } else if(data.request == "kick player"){
+                let player = this.players.find(player => player.user.username == data.username);
+                if(!player) return user.returnMessage("error", false, "That Player Is Not In The Game!", "player not found");
+                if(player.user == user) return user.returnMessage("error", true, "invalid request, the host can't kick themselves, use leave game");
+                let reason = "removed by the host";
+                if(data.reason){ // the reason is optional
+                    if(typeof data.reason != "string") return user.returnMessage("error", true, "invalid request, reason should be a string");
+                    reason = striptags(data.reason).trim().substring(0, 100) || reason;
                 }
+                return this.kickPlayer(player, reason);
+            } else if(data.request == "mute player"){ // stops, or with "muted": false lets again, a player chatting
+                let player = this.players.find(player => player.user.username == data.username);
+                if(!player) return user.returnMessage("error", false, "That Player Is Not In The Game!", "player not found");
+                if(typeof data.muted != "boolean") return user.returnMessage("error", true, "invalid request, muted should be true or false");
+                player.muted = data.muted;
+                this.recordEvent(data.muted ? "mute" : "unmute", {"username": player.user.username});
+                this.sendToPlayer(player, "update", true, {"muted": player.muted});
+                return this.broadcastGameData();
+            } else if(data.request == "stop game"){ // this is how endless games are ended, but it works for any win condition
+                clearTimeout(this.nextRoundTimeout);
+                return this.finishGame();
+            } else if(data.request == "change deck weight"){ // this makes the cards from a deck come up more often
+                let deck = this.decks.find(deck => deck.deckID == data.deckID);
+                if(!deck) return user.returnMessage("error", true, "invalid request, deck not added");
+                if(!Number.isInteger(data.weight) || data.weight < 1 || data.weight > 10) return user.returnMessage("error", true, "deck weight invalid range");
+                deck.weight = data.weight;
+                this.recordEvent("settings changed", {"deck weight": {"deckID": deck.deckID, "weight": deck.weight}});
+                return this.broadcastGameData();
             } else if(data.request == "add deck"){
                 if(data.deckID){ // checks to see if the deck ID is there
                     return this.addDeck(data.deckID, user);
                 } else {
                     return user.returnMessage("error", true, "invalid request");
                 }
+            } else if(data.request == "add bundle"){
+                if(!data.bundleID) return user.returnMessage("error", true, "invalid request");
+                return this.addBundle(data.bundleID, user);
             } else if(data.request == "remove deck"){
                 if(data.deckID){
                     return this.removeDeck(data.deckID, user);
                 } else {
                     return user.returnMessage("error", true, "invalid request");
                 }
+            } else if(data.request == "export deck"){ // before the first game starts, this is the cards that would be used
+                return user.returnMessage("update", true, {"exported deck": this.assembledDeck || this.assembleDeck()});
             } else if(data.request == "start game"){
-                if(this.players.length >= 3){ // checks to see if there are more than x amount of players
+                if(this.players.length >= this.minPlayers){ // checks to see if there are more than x amount of players
                     if(this.decks.length > 0){ // checks to see if there are any decks
-                        if(this.status != "setup" && this.status != "finished") return user.returnMessage("error", true, "invalid request, game already running!");
+                        let notReady = this.players.filter(player => player.user != this.host && !player.ready);
+                        if(notReady.length > 0 && !data.force) return user.returnMessage("error", false, `Waiting For ${notReady.map(player => player.user.username).join(", ")} To Be Ready!`, "players not ready"); // the host can still start with force
                         return this.startGame();
                     } else {
-                        return user.returnMessage("error", true, "invalid request, no decks selected");
+                        return user.returnMessage("error", true, "invalid request, no decks selected", "no decks");
                     }
                 } else {
-                    return user.returnMessage("error", true, `invalid request, not enough players, 3 are needed minimum, current: ${this.players.length}`);
+                    return user.returnMessage("error", true, `invalid request, not enough players, ${this.minPlayers} are needed minimum, current: ${this.players.length}`, "not enough players");
                 }
             } else if(data.request == "leave game"){
                 if(this.players.length > 1){  // if there is more than one player
@@ -277,75 +735,191 @@ module.exports = class Game {
                 return this.removePlayer(this.players.find(player => player.user == user));
             }
         }
-        if(user == this.czar){
-            if(data.request == "choose winner"){
-                if(this.status == "choosing winner"){
+        if(user == this.czar && !this.isVotingRound()){ // in voting rounds the czar plays like everyone else
+            if(data.request == "reading"){ // the czar's client sends this as they go through the plays, so everyone can see how far they've got
+                if(!Number.isInteger(data.index) || data.index < 0 || data.index >= this.getChosenCards().length) return user.returnMessage("error", true, "invalid request, index out of range");
+                if(this.winner.ws) return user.returnMessage("error", true, "invalid request, winner has already been chosen");
+                return this.broadcast("update", true, {"czar reading": {"play": data.index+1, "of": this.getChosenCards().length}}, this.players.filter(player => player.user != user));
+            }
+            if(data.request == "choose winner" || data.request == "pick winner"){ // pick winner is the same, but with the index of the play in "cards chosen" instead of a card ID
+                let player;
+                if(data.request == "pick winner"){
+                    let plays = this.getChosenCards();
+                    if(!Number.isInteger(data.index) || data.index < 0 || data.index >= plays.length) return user.returnMessage("error", true, "invalid request, index out of range");
+                    player = plays[data.index].player;
+                } else {
                     // the czar client submits the first card in the submitted winning cards!
                     //if(!data.cardID) return user.returnMessage("error", true, "invalid request, no cardID given"); // checks for cardID
                     if(!data.cardID) return user.returnMessage("error", true, "invalid request, no cardID given!");
-                    let player = this.players.find(player => player["cards chosen"].find(card => card.cardID == data.cardID));
+                    player = this.players.find(player => player["cards chosen"].find(card => card.cardID == data.cardID));
                     if(!player) return user.returnMessage("error", true, "invalid request, player not in game");
-                    //let card = this.getChosenCards.find(cardOBJArr => cardOBJArr.cards.find(cardOBJ => cardOBJ.card.cardID == data.cardID));
-                    //if(!card) return user.returnMessage("error", true, "invalid request, card(s) sent not in cardChosen");
-                    if(this.winner.ws) return user.returnMessage("error", true, "invalid request, winner has already been chosen");
-                    return this.chooseWinner(player);
-                } else {
-                    return user.returnMessage("error", true, "invalid request");
                 }
+                //let card = this.getChosenCards.find(cardOBJArr => cardOBJArr.cards.find(cardOBJ => cardOBJ.card.cardID == data.cardID));
+                //if(!card) return user.returnMessage("error", true, "invalid request, card(s) sent not in cardChosen");
+                if(this.winner.ws) return user.returnMessage("error", true, "invalid request, winner has already been chosen");
+                let note = "";
+                if(data.note){ // the czar's note is optional
+                    if(typeof data.note != "string") return user.returnMessage("error", true, "invalid request, note should be a string");
+                    note = striptags(data.note).trim();
+                    if(note.length > 100) return user.returnMessage("error", false, "Your Note Is Too Long, It Has To Be 100 Characters Or Less!", "note too long");
+                }
+                return this.chooseWinner(player, note);
+            } else if(data.request == "report card"){ // the czar can report an offensive custom card that was played
+                let player = this.players.find(player => player["cards chosen"].find(card => card.getID() == data.cardID));
+                let card = player ? player["cards chosen"].find(card => card.getID() == data.cardID) : false;
+                if(!card || card.deck != this.customDeck) return user.returnMessage("error", true, "invalid request, only played custom cards can be reported");
+                this.container.reportContent({
+                    "type": "custom card",
+                    "game name": this.gameName,
+                    "join code": this.joinCode,
+                    "card text": card.getCardText(),
+                    "added by": card.addedBy,
+                    "played by": player.user.username,
+                    "reported by": user.username,
+                    "black card": this.blackCard.getCardText(),
+                    "round": this.round
+                });
+                return user.returnMessage("done", false, "Card Reported!");
             }
         } else {
             if(data.request == "submit cards"){
-                if(this.status != "choosing white cards") return user.returnMessage("error", true, "invalid request, not choosing white cards");
-
-                if(!data.cards) return user.returnMessage("error", true, "invalid request, no cards array given");
-                if(data.cards.length != this.blackCard.getCardsToPick()) return user.returnMessage("error", true, "invalid request, wrong amount of cards chosen"); // I need to have these checks in two seperate lines, otherwise if cards didnt exist, it would crash saying "couldn't find length of unknown"
+                if(!Array.isArray(data.cards)) return user.returnMessage("error", true, "invalid request, no cards array given");
+                if(data.cards.length != this.blackCard.getCardsToPick()) return user.returnMessage("error", true, "invalid request, wrong amount of cards chosen", "wrong card count"); // I need to have these checks in two seperate lines, otherwise if cards didnt exist, it would crash saying "couldn't find length of unknown"
                 let player = this.players.find(player => player.user == user);
-                if(player["cards chosen"].length > 0) return user.returnMessage("error", true, "invalid request, cards already chosen this round"); // this is so the player cant submit multiple cards, if there is anything in the "cards chosen" array, they've already submitted their cards that round
+                if(player["cards chosen"].length > 0) return user.returnMessage("error", true, "invalid request, cards already chosen this round", "already submitted"); // this is so the player cant submit multiple cards, if there is anything in the "cards chosen" array, they've already submitted their cards that round
 
                 return this.playCards(data.cards, player);
+            } else if(data.request == "play cards"){ // the same as submit cards, but with the card IDs instead of where they are in the hand
+                if(!Array.isArray(data.cardIDs)) return user.returnMessage("error", true, "invalid request, no cardIDs array given");
+                if(data.cardIDs.length != this.blackCard.getCardsToPick()) return user.returnMessage("error", true, "invalid request, wrong amount of cards chosen", "wrong card count");
+                if(new Set(data.cardIDs).size != data.cardIDs.length) return user.returnMessage("error", true, "invalid request, duplicate card IDs", "duplicate cards");
+                let player = this.players.find(player => player.user == user);
+                if(player["cards chosen"].length > 0) return user.returnMessage("error", true, "invalid request, cards already chosen this round", "already submitted");
+                let indexes = data.cardIDs.map(cardID => player["cards in hand"].findIndex(card => card.getID() == cardID));
+                if(indexes.includes(-1)) return user.returnMessage("error", true, "invalid request, card not in hand", "card not in hand");
+                return this.playCards(indexes, player);
+            } else if(data.request == "retract cards"){ // this lets the player take their cards back and choose different ones, as long as the czar hasn't started judging
+                let player = this.players.find(player => player.user == user);
+                if(player["cards chosen"].length == 0) return user.returnMessage("error", true, "invalid request, no cards chosen this round");
+                return this.retractCards(player);
+            } else if(data.request == "vote"){
+                if(!this.isVotingRound()) return user.returnMessage("error", true, "invalid request, not voting");
+                if(!data.cardID) return user.returnMessage("error", true, "invalid request, no cardID given!");
+                let player = this.players.find(player => player["cards chosen"].find(card => card.cardID == data.cardID));
+                if(!player) return user.returnMessage("error", true, "invalid request, player not in game");
+                if(player.user == user) return user.returnMessage("error", false, "You Can't Vote For Your Own Cards!", "own cards");
+                if(this.votes.has(user)) return user.returnMessage("error", true, "invalid request, already voted this round");
+                if(this.winner.ws) return user.returnMessage("error", true, "invalid request, winner has already been chosen");
+                this.votes.set(user, player);
+                this.broadcast("update", true, {"progress": {"votes": this.votes.size, "of": this.getChosenCards().length}});
+                if(this.votes.size >= this.getChosenCards().length) return this.tallyVotes(); // once everyone who played has voted, the winner is chosen
+                return this.broadcastGameData();
             } else {
                 return user.returnMessage("error", true, "invalid request");
             }
         }
     }
-    chooseWinner(player){
+    chooseWinner(player, note = ""){
         player.score ++;
+        this.czarNote = note;
+        this.recordEvent("judge", {"winner": player.user.username, "cards": player["cards chosen"].map(card => card.getID()), "note": note});
+        this.roundHistory.push({
+            "round": this.round,
+            "black card": this.blackCard.getCardText(),
+            "winner": player.user.username,
+            "winning cards": player["cards chosen"].map(card => {
+                return {"text": card.getCardText(), "deck": card.deck.getDeckName()};
+            }),
+            "czar note": note
+        });
+        player["cards chosen"].forEach(card => this.container.recordCardStat(card, "won"));
         this.winner = player.user;
+        this.lastRoundWinner = player.user; // this.winner is cleared before the next czar is picked, so "winner becomes czar" uses this
+        this.reactions.clear();
         this.broadcastGameData();
+        let showingWinnerTime = this.roundTimes["showing winner"]*this.blackCard.cardsToPick; // Waits longer as it would take longer to read more cards
+        this.broadcast("update", true, {"round winner": {
+            "round": this.round,
+            "username": player.user.username,
+            "play": this.getChosenCards().findIndex(entry => entry.player == player), // the index in "cards chosen", so the client can highlight it
+            "cards": player["cards chosen"].map(card => card.getCardText()),
+            "note": note,
+            "next round at": Date.now()+showingWinnerTime
+        }}, this.players, true);
         clearTimeout(this.nextRoundTimeout);
         this.nextRoundTimeout = setTimeout(() => {
             this.goToNextStage();
-        }, this.roundTimes["showing winner"]*this.blackCard.cardsToPick); // Waits longer as it would take longer to read more cards
+        }, showingWinnerTime);
     }
     changeCzar(){
-        let index = this.players.findIndex(player => player.user == this.czar)+1; // gets the index of the czar
-        //index >= this.players.length ? this.czar = this.players[0].user : this.czar = this.players[index].user; // if the index of the old czar+1 is valid for a new index for the czar, set it, otherwise set czar to index 0
-        if(index >= this.players.length){
-            if(this.players.length > 0) {
-                this.czar = this.players[0].user;
-            } else {
-                this.container.removeGame(this); // gg bois, it was a good run
-            }
-        } else {
-            this.czar = this.players[index].user;
-        }
+        if(this.players.length == 0) return this.container.removeGame(this); // gg bois, it was a good run
+        this.czar = czarStrategies[this.czarStrategy](this);
+    }
+    addBundle(bundleID, user){ // adds every deck in the bundle that hasn't been added yet
+        this.skipDefaultDeck = true;
+        this.container.db.all("SELECT deckID FROM Bundle_Deck WHERE bundleID = ?", [bundleID], (err, rows) => {
+            if(err) return console.log(`Error adding bundle in game class: ${err}`);
+            if(rows.length == 0) return user.returnMessage("error", false, "That Bundle Does Not Exist!", "bundle not found");
+            rows.filter(row => !this.decks.find(deck => deck.deckID == row.deckID)).forEach(row => this.addDeck(row.deckID, user));
+        });
+    }
+    getSnapshot(){ // this is what's saved when the server shuts down, the custom cards, hands and round aren't kept
+        return {
+            "game name": this.gameName,
+            "password": this.password,
+            "host": this.host.username,
+            "settings": this.getSettings(),
+            "decks": this.decks.filter(deck => deck != this.customDeck).map(deck => {
+                return {"deckID": deck.deckID, "weight": deck.weight};
+            }),
+            "players": this.players.map(player => {
+                return {"username": player.user.username, "score": player.score};
+            }),
+            "saved at": Date.now()
+        };
     }
-    addDeck(deckID, user){
-        if(this.decks.find(deck => deck.deckID == deckID)) return user.returnMessage("error", false, "Deck Has Already Been Added!"); // checks to see if the deck has already been added
-        this.container.db.get("SELECT * FROM Card WHERE deckID = ?", [deckID], (err, row) => { // checks to see if the deck exists
+    restoreSnapshot(snapshot, user){ // the game comes back in the lobby with the same settings, decks and scores
+        this.skipDefaultDeck = true;
+        this.changeSettings(user, snapshot.settings);
+        snapshot.decks.forEach(deck => this.addDeck(deck.deckID, user, deck.weight));
+    }
+    addDeck(deckID, user, weight = 1){
+        if(this.decks.find(deck => deck.deckID == deckID)) return user.returnMessage("error", false, "Deck Has Already Been Added!", "deck already added"); // checks to see if the deck has already been added
+        this.container.db.get("SELECT Deck.nsfw FROM Deck JOIN Card ON Card.deckID = Deck.deckID WHERE Deck.deckID = ? AND ((Deck.public = true AND Deck.approved = true) OR Deck.userID = ?)", [deckID, user.userID], (err, row) => { // checks to see if the deck exists, has cards and the host can use it
             if(err) return console.log(`Error adding deck in game class: ${err}`);
             if(row){
-                this.decks.push(new Deck(deckID, this));
+                if(row.nsfw && this.familyFriendly) return user.returnMessage("error", false, "That Deck Is NSFW, Turn Off Family Friendly Mode To Add It!", "deck nsfw");
+                let deck = new Deck(deckID, this);
+                deck.weight = weight;
+                this.decks.push(deck);
+                this.recordEvent("settings changed", {"deck added": deckID});
                 this.broadcastGameData();
             } else {
-                user.returnMessage("error", false, "That Deck Does Not Exist!");
+                user.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
             }
         });
     }
+    addCustomCard(user, type, text, cardsToPick){
+        if(text.length < 1 || text.length > 100) return user.returnMessage("error", false, "Custom Cards Have To Be Between 1 And 100 Characters!", "invalid custom card");
+        if(!type && !(Number.isInteger(cardsToPick) && cardsToPick >= 1 && cardsToPick <= 3)) return user.returnMessage("error", true, "invalid request, black cards need 1 to 3 cards to pick");
+        if(!this.customDeck){ // the custom deck is added like any other deck, so the cards get mixed in with the rest
+            this.customDeck = new CustomDeck(this);
+            this.decks.push(this.customDeck);
+        }
+        if(this.customDeck.getCardCount(true)+this.customDeck.getCardCount(false) >= 20) return user.returnMessage("error", false, "There Can Only Be 20 Custom Cards In A Game!", "custom card limit");
+        if(this.customDeck.getCardsAddedBy(user.username).length >= 5) return user.returnMessage("error", false, "You Can Only Add 5 Custom Cards!", "custom card limit");
+        if(this.container.containsBannedWord(text)) return user.returnMessage("error", false, "That Card Has A Banned Word In It!", "banned word");
+        let card = this.customDeck.addCustomCard(type, text, cardsToPick, user.username);
+        this.recordEvent("settings changed", {"custom card added": card.getID(), "added by": user.username});
+        user.returnMessage("update", true, {"custom card added": {"card id": card.getID(), "card text": text}});
+        return this.broadcastGameData();
+    }
     removeDeck(deckID, user){
         let Odeck = this.decks.find(deck => deckID == deck.deckID);
         if(!Odeck) return user.returnMessage("error", true, "invalid request, deck not added");
         this.decks = this.decks.filter(deck => deck != Odeck)
+        if(Odeck == this.customDeck) this.customDeck = null;
+        this.recordEvent("settings changed", {"deck removed": deckID});
         this.broadcastGameData();
     }
     sendGameData(player){
@@ -356,20 +930,38 @@ module.exports = class Game {
             game: {
                 "host": this.host.username,
                 "game name": this.gameName,
+                "join code": this.joinCode, // so the players can give it to their friends, it's not in the games list
                 "decks added": this.getDecksAdded(), // returns, in an array, for every deck, with it's name and ID
+                "cards remaining": this.getCardsRemaining(),
                 "players": this.getPlayerList(), 
                 "czar": this.czar.username,
                 "winner": this.winner.ws ? this.winner.username : "",
+                "czar note": this.czarNote,
                 "black card": this.blackCard ? {"text": this.blackCard.getCardText(), "cards to pick": this.blackCard.getCardsToPick()} : null,
                 "cards chosen": this.getChosenCardsToSend(player),
                 "cards in hand": this.getCardsInHand(player),
                 "round": this.round, 
                 "rounds": this.rounds,
+                "win condition": this.winCondition,
+                "czar strategy": this.czarStrategy,
+                "min players": this.minPlayers,
+                "settings version": this.settingsVersion,
+                "voting": this.isVotingRound(),
+                "points to win": this.pointsToWin,
+                "family friendly": this.familyFriendly,
+                "deduplicate cards": this.deduplicateCards,
+                "infinite deck": this.infiniteDeck,
+                "public": this.public,
                 "status": this.status, 
+                "sequence": this.sequence,
                 "stage ending time": this.stageEndingTime/*,
                 "winning card": this.winningCard ? {"cardID": this.winningCard.card.getID(), "player": this.winningCard.play.user.username} : null*/
             }
         };
+        if(player.user.compactMode){ // compact mode leaves out the bigger data, the client can look it up with the deck IDs from "decks available" and the "get settings" request
+            dataToSend.game["decks added"] = this.decks.map(deck => deck.deckID);
+            ["win condition", "czar strategy", "min players", "points to win", "family friendly", "deduplicate cards", "infinite deck", "public"].forEach(setting => delete dataToSend.game[setting]);
+        }
         if(dataToSend != player.lastDataSent){ // if the data that was sent last has changed
             let reducedData = {game: {}};
             Object.keys(dataToSend.game).forEach((item) => { // this adds the changed data to the object "reducedData", saving bandwidth
@@ -378,17 +970,51 @@ module.exports = class Game {
                 }
             });
             player.lastDataSent = dataToSend;
+            reducedData.game.checksum = this.getChecksum(dataToSend.game); // the client can check this against its copy after adding the changes, and ask for a resync if it's different
             //let reducedJSONdata = JSON.stringify(reducedData);
-            player.user.returnMessage("update", true, reducedData);
+            this.sendToPlayer(player, "update", true, reducedData); // it has their hand in it, so it's only ever sent to them
         }
     }
+    getChecksum(gameData){ // the first 8 characters of the sha256 of [[key, value], ...] as JSON, with the keys in alphabetical order
+        let entries = Object.keys(gameData).sort().map(key => [key, gameData[key]]);
+        return crypto.createHash('sha256').update(JSON.stringify(entries)).digest('hex').substring(0, 8);
+    }
+    resync(player){ // sends everything again, for when the client's copy of the game has gone wrong
+        player.lastDataSent = {game:{}};
+        this.sendGameData(player);
+        this.sendToPlayer(player, "update", true, {"round info": this.getRoundInfo(player)});
+        player.user.sendNotification("chat", "update", true, {"chat history": this.chatHistory});
+    }
+    getRoundStart(){ // what's the same for everyone at the start of a round
+        return {
+            "round": this.round,
+            "black card": {"text": this.blackCard.getCardText(), "cards to pick": this.blackCard.getCardsToPick()},
+            "czar": this.czar.username,
+            "voting": this.isVotingRound(),
+            "stage ending time": this.stageEndingTime
+        };
+    }
+    getRoundInfo(player){ // the current round from this player's point of view, for clients that have lost track
+        return {
+            "round": this.round,
+            "status": this.status,
+            "czar": this.czar.username,
+            "black card": this.blackCard ? {"text": this.blackCard.getCardText(), "cards to pick": this.blackCard.getCardsToPick()} : null,
+            "stage ending time": this.stageEndingTime,
+            "your cards chosen": player["cards chosen"].map(card => {
+                return {"ID": card.getID(), "text": card.getCardText()};
+            }),
+            "voted": this.votes.has(player.user),
+            "submitted": this.getChosenCards().length
+        };
+    }
     getChosenCards(){
         return this.players.filter(player => player["cards chosen"].length > 0).map((player) => { // for every player, get their cards chosen
             return {"player": player, "cards": player["cards chosen"]};
         });
     }
     getChosenCardsToSend(player){ // this function exists because the czar shouldn't get the player names for who submitted what
-        if(player.user == this.czar && !this.winner.ws){
+        if((player.user == this.czar || this.isVotingRound()) && !this.winner.ws){ // in voting rounds no one gets to see who played what until the winner is chosen
             return this.getChosenCards().map((entry) => {
                 //console.log(`Cards: ${JSON.stringify(cards)}`);
                 return {
@@ -399,7 +1025,9 @@ module.exports = class Game {
                 
             });
         } else {
-            return this.getChosenCards().map((entry) => {
+            // while people are still choosing, the other players only get to see their own cards, who played what is shown once the winner's picked
+            let chosenCards = this.status == "choosing white cards" ? this.getChosenCards().filter(entry => entry.player == player) : this.getChosenCards();
+            return chosenCards.map((entry) => {
                 //console.log(`Cards: ${JSON.stringify(cards)}`);
                 return {
                     "username": entry.player.user.username, 
@@ -416,7 +1044,28 @@ module.exports = class Game {
             return {"ID": card.getID(), "text": card.getCardText()};
         });
     }
+    // messages that are the same for everyone are turned into JSON once here and then sent to each player, players can be given to only send to some of them
+    // ackRequired is for messages that can't be missed, the client has to ack them or they're sent again
+    broadcast(type, internal, content, players = this.players, ackRequired = false){
+        if(containsPrivateData(content)) return console.log(`Not broadcasting a ${type} in ${this.gameName}, it has a player's private data in it`); // it should have gone through sendToPlayer
+        let contentJSON = JSON.stringify(content);
+        players.forEach(player => player.user.sendPreparedMessage(type, internal, contentJSON, ackRequired));
+    }
+    broadcastNotification(category, type, internal, content, players = this.players){ // the same but for messages players can turn off
+        if(containsPrivateData(content)) return console.log(`Not broadcasting a ${type} in ${this.gameName}, it has a player's private data in it`);
+        let contentJSON = JSON.stringify(content);
+        players.forEach(player => player.user.sendPreparedNotification(category, type, internal, contentJSON));
+    }
+    sendToPlayer(player, type, internal, content){ // this is the only way a player's private data, like their hand, is sent
+        if(!this.players.includes(player)) return console.log(`Tried to send a ${type} to someone who isn't a player in ${this.gameName}`);
+        player.user.returnMessage(type, internal, content);
+    }
+    sendToCzar(type, internal, content){
+        let czar = this.players.find(player => player.user == this.czar);
+        if(czar) this.sendToPlayer(czar, type, internal, content);
+    }
     broadcastGameData(){
+        this.sequence++; // every player gets the same number for the same broadcast
         this.players.forEach((player) => {
             this.sendGameData(player);
         });
@@ -425,11 +1074,77 @@ module.exports = class Game {
     }
     getPlayerList(){
         return this.players.map(player => {
-            return {"username": player.user.username, "score": player.score};
+            return {"username": player.user.username, "score": player.score, "ready": player.ready, "muted": player.muted};
         });
     }
+    getSettings(){
+        return {
+            "max cards in hand": this.maxCardsInHand,
+            "rounds": this.rounds,
+            "win condition": this.winCondition,
+            "points to win": this.pointsToWin,
+            "czar strategy": this.czarStrategy,
+            "min players": this.minPlayers,
+            "family friendly": this.familyFriendly,
+            "deduplicate cards": this.deduplicateCards,
+            "infinite deck": this.infiniteDeck,
+            "public": this.public,
+            "seed": this.seed
+        };
+    }
+    validateSettings(settings){ // returns what is wrong with the settings, or an empty string if they're valid
+        let inRange = (value, min, max) => Number.isInteger(value) && value >= min && value <= max;
+        if(!inRange(settings["max cards in hand"], 5, 40)) return "max cards invalid range";
+        if(!inRange(settings["rounds"], 1, 50)) return "rounds invalid range";
+        if(!["first to points", "most points after max rounds", "endless"].includes(settings["win condition"])) return "invalid win condition";
+        if(!inRange(settings["points to win"], 1, 50)) return "points to win invalid range";
+        if(!czarStrategies.hasOwnProperty(settings["czar strategy"])) return "invalid czar strategy";
+        if(!inRange(settings["min players"], 2, 10)) return "min players invalid range";
+        if(typeof settings["family friendly"] != "boolean") return "family friendly should be true or false";
+        if(typeof settings["deduplicate cards"] != "boolean") return "deduplicate cards should be true or false";
+        if(typeof settings["infinite deck"] != "boolean") return "infinite deck should be true or false";
+        if(typeof settings["public"] != "boolean") return "public should be true or false";
+        if(settings["seed"] !== null && !inRange(settings["seed"], 0, 4294967295)) return "seed should be a whole number or null";
+        return "";
+    }
+    changeSettings(user, patch){
+        if(typeof patch != "object" || patch === null || Array.isArray(patch)) return user.returnMessage("error", true, "invalid request, settings should be an object");
+        let settings = this.getSettings();
+        for(let key of Object.keys(patch)){ // this merges the patch over the current settings
+            if(!defaultSettings.hasOwnProperty(key)) return user.returnMessage("error", true, `invalid request, ${key} is not a setting`);
+            settings[key] = patch[key] === null ? defaultSettings[key] : patch[key];
+        }
+        let error = this.validateSettings(settings); // the whole merged result is checked, not just the changes
+        if(error) return user.returnMessage("error", true, `invalid request, ${error}`);
+        if(settings["infinite deck"] != this.infiniteDeck && !["setup", "finished"].includes(this.status)) return user.returnMessage("error", false, "Infinite Deck Can Only Be Changed Between Games!", "game running"); // the cards in peoples hands would be lost or doubled up
+        if(settings["family friendly"] != this.familyFriendly && !["setup", "finished"].includes(this.status)) return user.returnMessage("error", false, "Family Friendly Can Only Be Changed Between Games!", "game running"); // taking the NSFW decks out could leave no cards for the next round
+        this.rounds = settings["rounds"];
+        this.winCondition = settings["win condition"];
+        this.pointsToWin = settings["points to win"];
+        this.czarStrategy = settings["czar strategy"];
+        this.minPlayers = settings["min players"];
+        this.familyFriendly = settings["family friendly"];
+        this.deduplicateCards = settings["deduplicate cards"];
+        this.infiniteDeck = settings["infinite deck"];
+        this.public = settings["public"];
+        this.seed = settings["seed"];
+        if(this.familyFriendly) this.decks = this.decks.filter(deck => !deck.nsfw); // any NSFW decks already added are taken out
+        this.updateMaxCardsInHand(settings["max cards in hand"]);
+        this.settingsVersion++;
+        this.recordEvent("settings changed", Object.assign({"version": this.settingsVersion}, patch));
+        this.container.sendGamesUpdate(); // the rounds and win condition are shown on the games page
+        this.sendSettingsChanged(user);
+        return this.broadcastGameData();
+    }
+    sendSettingsChanged(user){ // everyone gets the new settings, but only the host gets the seed, the password isn't a setting so it's never in them
+        let settings = this.getSettings();
+        let host = this.players.find(player => player.user == this.host);
+        if(host) this.sendToPlayer(host, "update", true, {"settings changed": {"settings": settings, "settings version": this.settingsVersion, "changed by": user.username}});
+        delete settings["seed"];
+        this.broadcast("update", true, {"settings changed": {"settings": settings, "settings version": this.settingsVersion, "changed by": user.username}}, this.players.filter(player => player != host));
+    }
     updateMaxCardsInHand(max){
-        if(max > 39 || max < 6){
+        if(max > 40 || max < 5){
             console.log(`could not update maxCardsInHand in game class, ${max} is not within range`);
         } else {
             this.maxCardsInHand = max;
@@ -443,7 +1158,7 @@ module.exports = class Game {
     }
     getDecksAdded(){ 
         return this.decks.map((deck) => {
-            return {"id": deck.deckID, "name": deck.getDeckName(), "white card count": deck.getCardCount(true), "black card count": deck.getCardCount(false)}
+            return {"id": deck.deckID, "name": deck.getDeckName(), "white card count": deck.getCardCount(true), "black card count": deck.getCardCount(false), "weight": deck.weight}
         });
     }
     playCards(cards, player){ // cards should be an array of indexes
@@ -452,26 +1167,33 @@ module.exports = class Game {
             "cards": card
         };
         this.chosenCards.push(chosenCard); */
-        for(var i=0; i < cards.length;i++){
-            for(var j=cards.length; j > i+1; j--){
-                if(cards[i] == cards[j]){
-                    return player.user.returnMessage("error", true, "invalid request, duplicate indexes!");
-                }
-            }
-        }
-        for(var i=0; i < cards.length; i++){
-            let cardIndex = cards[i];
-            if(cardIndex < 0 || cardIndex > player["cards in hand"].length) return player.user.returnMessage("error", true, "invalid request, card index out of range");
-            player["cards chosen"].push(player["cards in hand"][cardIndex]);
-        }
+        if(new Set(cards).size != cards.length) return player.user.returnMessage("error", true, "invalid request, duplicate indexes!", "duplicate cards");
+        // all of them are checked before any are played, so a bad index doesn't leave half of the cards played
+        if(cards.some(cardIndex => !Number.isInteger(cardIndex) || cardIndex < 0 || cardIndex >= player["cards in hand"].length)) return player.user.returnMessage("error", true, "invalid request, card index out of range", "card not in hand");
+        cards.forEach(cardIndex => player["cards chosen"].push(player["cards in hand"][cardIndex]));
+        player["cards chosen"].forEach(card => this.container.recordCardStat(card, "played"));
+        this.recordEvent("play", {"username": player.user.username, "cards": player["cards chosen"].map(card => card.getID())});
         player["cards in hand"] = player["cards in hand"].filter((card) => !player["cards chosen"].find(chosenCard => chosenCard == card));
+        this.broadcast("update", true, {"player submitted": player.user.username}, this.players.filter(other => other != player)); // who, but not what
 
-        if(this.getChosenCards().length >= this.players.length-1){
+        this.sendProgress();
+        if(this.getChosenCards().length >= (this.isVotingRound() ? this.players.length : this.players.length-1)){
             this.goToNextStage();
         } else {
             this.broadcastGameData();
         }
     }
+    retractCards(player){
+        player["cards chosen"].forEach(card => this.container.recordCardStat(card, "played", -1));
+        this.recordEvent("retract", {"username": player.user.username, "cards": player["cards chosen"].map(card => card.getID())});
+        player["cards in hand"] = player["cards in hand"].concat(player["cards chosen"]); // puts the cards back in their hand
+        player["cards chosen"] = [];
+        this.sendProgress();
+        this.broadcastGameData();
+    }
+    sendProgress(){ // how many people have played, without saying who, so the clients can show a progress bar
+        this.broadcast("update", true, {"progress": {"submitted": this.getChosenCards().length, "of": this.isVotingRound() ? this.players.length : this.players.length-1}});
+    }
     setHost(host){ // host should be user
         if(this.status == "setup"){
             this.host = host;
@@ -479,17 +1201,25 @@ module.exports = class Game {
         } else {
             this.host = host;
         }
+        this.broadcast("update", true, {"host changed": host.username});
     }
     finishGame(){
-        this.status = "finished";
+        this.setStatus("finished");
+        this.summary = this.makeSummary(); // this has to be done before the scores and decks are reset
+        if(this.roundHistory.length > 0) this.container.archiveSummary(this.summary); // games can finish in the lobby when people leave, there's nothing to keep then
         this.decks = [];
         this.czar = this.host;
+        this.lastRoundWinner = {}; // so the next game doesn't start with the last game's winner as czar
+        this.customDeck = null; // the decks are cleared, so the custom cards go with them
         this.winner = {};
+        this.czarNote = "";
+        this.votes.clear();
         this.round = 0;
         this.decks = [];
         this.players.forEach((player) => {
             player["cards chosen"] = [];
             player["cards in hand"] = [];
+            player.ready = false; // everyone has to ready up again for the next game
             // db.exec("INSERT INTO Game_History (userID, time, score) VALUES (1, 1570284327, 11)");
             this.container.db.run("INSERT INTO Game_History (userID, time, score) VALUES (?, ?, ?)", (player.user.userID, Date.now(), player.score), (err) => {
                 if(err) console.log("Error inserting into game history: "+err);
@@ -498,6 +1228,39 @@ module.exports = class Game {
         });
         this.broadcastGameData();
     }
+    makeSummary(){ // everything the results screen needs in one object
+        let scores = this.players.map(player => {
+            return {"username": player.user.username, "score": player.score};
+        }).sort((a, b) => b.score-a.score);
+        let awards = [];
+        if(scores.length > 0 && scores[0].score > 0){
+            awards.push({"award": "Winner", "usernames": scores.filter(score => score.score == scores[0].score).map(score => score.username)});
+        }
+        if(this.roundHistory.length > 0){
+            awards.push({"award": "First Point", "usernames": [this.roundHistory[0].winner]});
+            let streak = {"username": "", "length": 0};
+            let current = {"username": "", "length": 0};
+            this.roundHistory.forEach((round) => { // finds the most rounds in a row won by one player
+                current = round.winner == current.username ? {"username": current.username, "length": current.length+1} : {"username": round.winner, "length": 1};
+                if(current.length > streak.length) streak = current;
+            });
+            if(streak.length > 1) awards.push({"award": `Won ${streak.length} Rounds In A Row`, "usernames": [streak.username]});
+        }
+        let decks = this.getDecksAdded().map((deck) => { // how many winning cards came from each deck
+            let wins = 0;
+            this.roundHistory.forEach(round => round["winning cards"].forEach(card => wins += card.deck == deck.name ? 1 : 0));
+            return {"id": deck.id, "name": deck.name, "winning cards": wins};
+        });
+        return {
+            "game name": this.gameName,
+            "finished at": Date.now(),
+            "rounds played": this.roundHistory.length,
+            "scores": scores,
+            "awards": awards,
+            "round history": this.roundHistory,
+            "decks": decks
+        };
+    }
     changeHost(newHost){// depreciated
         if(newHost){
             if(this.player.find(player => player == newHost)){
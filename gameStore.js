@@ -0,0 +1,25 @@
+// This is where the container keeps the games, the games are stored by name as game names are unique
+// anything with the same get, put, delete and list methods can be passed to the container instead, like a store that saves the games to a database
+module.exports = class MemoryGameStore {
+    constructor(){
+        this.games = new Map(); // game name => game, a Map keeps the games in the order they were made
+        this.joinCodes = new Map(); // join code => game name
+    }
+    get(name){ // returns false if there's no game with that name, like user.getGame()
+        return this.games.get(name) || false;
+    }
+    getByJoinCode(code){
+        return this.get(this.joinCodes.get(code.toUpperCase()));
+    }
+    put(game){
+        this.games.set(game.gameName, game);
+        this.joinCodes.set(game.joinCode, game.gameName);
+    }
+    delete(game){ // only if it's still the one stored, a new game could have been made with the same name
+        if(this.games.get(game.gameName) === game) this.games.delete(game.gameName);
+        if(this.joinCodes.get(game.joinCode) === game.gameName) this.joinCodes.delete(game.joinCode);
+    }
+    list(){
+        return Array.from(this.games.values());
+    }
+}
@@ -17,83 +17,141 @@ this.getChosenCardsToSend(player) bit funny does a null per user...
 */
 
 var http = require('http');
-var striptags = require('striptags');
+var https = require('https');
 const WebSocket = require('ws');
 var sqlite3 = require('sqlite3').verbose();
 const crypto = require('crypto');
 const Container = require("./container.js");
+const CardLoader = require("./cardLoader.js");
+const closeCodes = require("./closeCodes.js");
 var fs = require('fs'); 
-var db = new sqlite3.Database(':memory:');
-//var db = new sqlite3.Database('userDatabase.db');
+var db = new sqlite3.Database(process.env.DATABASE_PATH || ':memory:'); // set DATABASE_PATH to a file (like userDatabase.db) to keep the users and decks when the server restarts
+
+const sandbox = process.argv.includes("--sandbox"); // "node main.js --sandbox" is for working on the client, it only loads the small tech support deck and logs everything
+if(sandbox) console.log("Running in sandbox mode");
+var cardLoader = new CardLoader(db, sandbox); // this loads the card files into the database, it can be ran again to reload them
 
 createDatabase();
-const wss = new WebSocket.Server({ port: 8081 }); // Initiates the websocket and sets the port to 8080
-var container = new Container(wss, db); // initiates the container, this hosts the game, the WebSocketServer server and the DataBase are passed on when it is initialised
+const wss = createWebSocketServer(process.env.PORT || 8081); // Initiates the websocket and sets the port to 8081 unless the PORT environment variable is set
+var container = new Container(wss, db, cardLoader, sandbox); // initiates the container, this hosts the game, the WebSocketServer server and the DataBase are passed on when it is initialised
+process.on('SIGHUP', () => { // "kill -HUP" reloads the card files without restarting
+  console.log("SIGHUP recieved, reloading decks");
+  cardLoader.loadAll();
+});
+['SIGINT', 'SIGTERM'].forEach((signal) => { // if SNAPSHOT_PATH is set, the games are saved there on shutdown and come back when the server starts again
+  process.on(signal, () => {
+    if(process.env.SNAPSHOT_PATH) container.saveSnapshots(process.env.SNAPSHOT_PATH);
+    container.closeAll(closeCodes["server shutdown"], "server restarting");
+    setTimeout(() => process.exit(0), 1000); // gives the close frames a moment to go out
+  });
+});
+
 
+function createWebSocketServer(port){ // if TLS_CERT and TLS_KEY are set to the certificate files, the websocket uses wss://, otherwise it's plain ws://
+  const server = process.env.TLS_CERT && process.env.TLS_KEY ? createTLSServer() : http.createServer();
+  server.on('request', (req, res) => { // normal HTTP requests are only for Prometheus to get the metrics
+    if(req.method == "GET" && req.url == "/metrics"){
+      res.writeHead(200, {"Content-Type": "text/plain; version=0.0.4"});
+      return res.end(container.getMetrics());
+    }
+    if(req.method == "GET" && req.url == "/debug/connections" && checkDebugToken(req)){ // this has IPs in it, so it needs DEBUG_TOKEN
+      res.writeHead(200, {"Content-Type": "application/json"});
+      return res.end(JSON.stringify(container.getConnections()));
+    }
+    res.writeHead(404);
+    res.end();
+  });
+  server.listen(port);
+  // messages are compressed for clients that support it, the small ones aren't worth the CPU time, set DISABLE_COMPRESSION to turn it off
+  const perMessageDeflate = process.env.DISABLE_COMPRESSION ? false : { threshold: 1024, zlibDeflateOptions: { level: 6 } };
+  // messages bigger than MAX_MESSAGE_SIZE bytes close the connection before they're read in, custom decks are the biggest messages so it's big enough for those
+  const maxPayload = parseInt(process.env.MAX_MESSAGE_SIZE) || 1024*1024;
+  return new WebSocket.Server({ server: server, perMessageDeflate: perMessageDeflate, verifyClient: checkOrigin, maxPayload: maxPayload });
+}
+// browsers send the page's origin when opening a websocket, this stops other sites opening one with the player's cookies
+// by default the page has to be on the same hostname as the server (any port, as the client is served separately)
+// ALLOWED_ORIGINS is a comma separated list of other origins, like "https://cah.example.com", ALLOW_ALL_ORIGINS turns the check off for development
+const allowedOrigins = (process.env.ALLOWED_ORIGINS || "").split(",").map(origin => origin.trim()).filter(origin => origin.length > 0);
+function checkOrigin(info){
+  if(process.env.ALLOW_ALL_ORIGINS || sandbox) return true;
+  if(!info.origin) return true; // only browsers send an origin, and other clients can't be made to use someone else's cookies
+  if(allowedOrigins.includes(info.origin)) return true;
+  try{
+    if(new URL(info.origin).hostname == new URL(`http://${info.req.headers.host}`).hostname) return true;
+  } catch(e) {} // an origin that isn't a URL, like "null" from a file:// page
+  console.log(`Websocket from ${info.origin} refused, add it to ALLOWED_ORIGINS if it should be allowed`);
+  return false;
+}
+function checkDebugToken(req){ // the header has to be "Authorization: Bearer <DEBUG_TOKEN>", if DEBUG_TOKEN isn't set the debug pages are turned off
+  if(!process.env.DEBUG_TOKEN) return false;
+  let expected = Buffer.from(`Bearer ${process.env.DEBUG_TOKEN}`);
+  let given = Buffer.from(req.headers["authorization"] || "");
+  return given.length == expected.length && crypto.timingSafeEqual(given, expected); // timingSafeEqual so the token can't be guessed a character at a time
+}
+function createTLSServer(){
+  const loadCertificate = () => { return {cert: fs.readFileSync(process.env.TLS_CERT), key: fs.readFileSync(process.env.TLS_KEY)}; };
+  const server = https.createServer(loadCertificate());
+  fs.watchFile(process.env.TLS_CERT, () => { // when the certificate is renewed (by certbot for example), it's swapped in without restarting
+    try{
+      server.setSecureContext(loadCertificate());
+      console.log("TLS certificate reloaded");
+    } catch(e) {
+      console.log(`Error reloading TLS certificate: ${e}`);
+    }
+  });
+  console.log(`Using TLS with certificate ${process.env.TLS_CERT}`);
+  return server;
+}
 
-function createDatabase(){ // This creates a fresh database everytime the game is restarted
+function createDatabase(){ // This creates the database if it doesn't exist yet, with an in memory database this is everytime the game is restarted
     db.serialize(() => {
       // *********** Creating the database structure ***********
-      db.run("CREATE TABLE User (userID INTEGER PRIMARY KEY AUTOINCREMENT, username varchar(20), password varchar(64), email varchar(60), joinedAt INTEGER, admin BOOLEAN DEAFULT false)");
-      db.run("CREATE TABLE Game_History (ID INTEGER PRIMARY KEY AUTOINCREMENT, userID INTEGER, time INTEGER, score INTEGER, FOREIGN KEY(userID) REFERENCES User(userID))");
-      db.run("CREATE TABLE Deck (deckID INTEGER PRIMARY KEY AUTOINCREMENT, name varchar(20), userID INTEGER, time INTEGER, public BOOLEAN, FOREIGN KEY(userID) REFERENCES User(userID))");
-      db.run("CREATE TABLE Card (cardID INTEGER PRIMARY KEY AUTOINCREMENT, deckID INTEGER, cardType BOOLEAN, cardText varchar(120), cardsToPick INTEGER, FOREIGN KEY(deckID) REFERENCES Deck(deckID))");
-      
-      // *********** Inserting the test data ***********
-      db.exec("INSERT INTO User (username, password, email, joinedAt) VALUES ('coolKid', 'd0c6945e8be5220078ed7caf38292c3f43558ffe530e3e75e0c6b5f9a2fb067b', 'mrcool@dank.com', 456345345444)");
-      db.exec("INSERT INTO User (username, password, email, joinedAt) VALUES ('coolKid1', 'd0c6945e8be5220078ed7caf38292c3f43558ffe530e3e75e0c6b5f9a2fb067b', 'mrcool@dank1.com', 456345345444)");
-      db.exec("INSERT INTO Game_History (userID, time, score) VALUES (1, 1570284327, 11)");
-      db.exec("INSERT INTO Deck (userID, time, name, public) VALUES (1, 1570284327, 'The Best Deck', true)");
-      db.exec("INSERT INTO Card (deckID, cardType, cardText, cardsToPick) VALUES (1, false, 'Elon Musk went to the hospital with ______ stuck up _____', 2)");
-      db.exec("INSERT INTO Card (deckID, cardType, cardText, cardsToPick) VALUES (1, true, 'A Falcon Rocket', 0)");
-      db.exec("INSERT INTO Card (deckID, cardType, cardText, cardsToPick) VALUES (1, true, 'Harvey Winestein', 0)");
-      
-      fs.readFile('cards.json', function(err, data) {// this opens the cards.json file and returns the contents as "data"
-        if(err) return console.log(`Error reading file: ${err}`);
-        var cards = JSON.parse(data); // parses the JSON into a JS object
-        db.run("INSERT INTO Deck (userID, time, name, public) VALUES (1, 1570359538858, 'tech support deck', true)", (err) => { // This creates the deck in the deck table
-          if(err) return console.log(`Error creating deck: ${err}`);
-        });
-        
-        // below inserts the cards into the card table, linking it to the first DB 
-        cards["white cards"].forEach(text => {
-          db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (2, true, 0, ?)", [text], (err) => {
-            if(err) return console.log(`Error inserting card into datbase: ${err}`);
-          });
-        });
-        cards["black cards"].forEach(obj => {
-          db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (2, false, ?, ?)", [obj.cards, obj.text], (err) => {
-            if(err) return console.log(`Error inserting card into datbase: ${err}`);
-          });
-        });
-      });
-
-      fs.readFile('json-against-humanity/dev/cah.json', function(err, data) {// this opens the cards.json file and returns the contents as "data"
-        if(err) return console.log(`Error reading file: ${err}`);
-        var cards = JSON.parse(data);
-
-        db.run("INSERT INTO Deck (userID, time, name, public) VALUES (1, 1570359538858, 'lots of decks', true)", (err) => { // This creates the deck in the deck table
-          if(err) return console.log(`Error creating deck: ${err}`);
-        });
-        
-        cards["blackCards"].forEach(card => {
-          if(card.text.length > 100) return; // removes all the really long question cards
-          if(card.text.split(" ").find(word => word.length > 20)) return;
-          db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (3, false, ?, ?)", [card.pick, striptags(card.text)], (err) => {
-            if(err) return console.log(`Error inserting card into datbase: ${err}`);
-          });
+      db.run("CREATE TABLE IF NOT EXISTS User (userID INTEGER PRIMARY KEY AUTOINCREMENT, username varchar(20), password varchar(64), email varchar(60), joinedAt INTEGER, admin BOOLEAN DEAFULT false)");
+      db.run("CREATE TABLE IF NOT EXISTS Game_History (ID INTEGER PRIMARY KEY AUTOINCREMENT, userID INTEGER, time INTEGER, score INTEGER, FOREIGN KEY(userID) REFERENCES User(userID))");
+      db.run("CREATE TABLE IF NOT EXISTS Deck (deckID INTEGER PRIMARY KEY AUTOINCREMENT, name varchar(20), userID INTEGER, time INTEGER, public BOOLEAN, nsfw BOOLEAN DEFAULT false, approved BOOLEAN DEFAULT true, official BOOLEAN DEFAULT false, description TEXT, icon TEXT, FOREIGN KEY(userID) REFERENCES User(userID))");
+      db.run("CREATE TABLE IF NOT EXISTS Deck_Rating (userID INTEGER, deckID INTEGER, rating INTEGER, PRIMARY KEY(userID, deckID), FOREIGN KEY(userID) REFERENCES User(userID), FOREIGN KEY(deckID) REFERENCES Deck(deckID))");
+      db.run("CREATE TABLE IF NOT EXISTS Deck_Favourite (userID INTEGER, deckID INTEGER, PRIMARY KEY(userID, deckID), FOREIGN KEY(userID) REFERENCES User(userID), FOREIGN KEY(deckID) REFERENCES Deck(deckID))");
+      db.run("CREATE TABLE IF NOT EXISTS Card (cardID INTEGER PRIMARY KEY AUTOINCREMENT, deckID INTEGER, cardType BOOLEAN, cardText varchar(120), cardsToPick INTEGER, FOREIGN KEY(deckID) REFERENCES Deck(deckID))");
+      db.run("CREATE TABLE IF NOT EXISTS Bundle (bundleID INTEGER PRIMARY KEY AUTOINCREMENT, name varchar(30))");
+      db.run("CREATE TABLE IF NOT EXISTS Bundle_Deck (bundleID INTEGER, deckID INTEGER, PRIMARY KEY(bundleID, deckID), FOREIGN KEY(bundleID) REFERENCES Bundle(bundleID), FOREIGN KEY(deckID) REFERENCES Deck(deckID))");
+      db.run("CREATE TABLE IF NOT EXISTS Game_Archive (archiveID varchar(16) PRIMARY KEY, gameName varchar(25), finishedAt INTEGER, summary TEXT)");
+      db.run("CREATE TABLE IF NOT EXISTS Card_Stat (cardID INTEGER PRIMARY KEY, played INTEGER DEFAULT 0, won INTEGER DEFAULT 0, FOREIGN KEY(cardID) REFERENCES Card(cardID))");
+      addMissingColumns(() => db.serialize(() => {
+        // *********** Inserting the test data ***********
+        db.get("SELECT COUNT(*) AS users FROM User", (err, row) => { // the test data is only added to a new database
+          if(err) return console.log(`Error checking for test data: ${err}`);
+          if(row.users == 0){
+            db.exec("INSERT INTO User (username, password, email, joinedAt) VALUES ('coolKid', 'd0c6945e8be5220078ed7caf38292c3f43558ffe530e3e75e0c6b5f9a2fb067b', 'mrcool@dank.com', 456345345444)");
+            db.exec("INSERT INTO User (username, password, email, joinedAt) VALUES ('coolKid1', 'd0c6945e8be5220078ed7caf38292c3f43558ffe530e3e75e0c6b5f9a2fb067b', 'mrcool@dank1.com', 456345345444)");
+            db.exec("INSERT INTO Game_History (userID, time, score) VALUES (1, 1570284327, 11)");
+            db.exec("INSERT INTO Deck (userID, time, name, public, nsfw) VALUES (1, 1570284327, 'The Best Deck', true, true)");
+            db.exec("INSERT INTO Card (deckID, cardType, cardText, cardsToPick) VALUES (1, false, 'Elon Musk went to the hospital with ______ stuck up _____', 2)");
+            db.exec("INSERT INTO Card (deckID, cardType, cardText, cardsToPick) VALUES (1, true, 'A Falcon Rocket', 0)");
+            db.exec("INSERT INTO Card (deckID, cardType, cardText, cardsToPick) VALUES (1, true, 'Harvey Winestein', 0)");
+          }
+          cardLoader.moveOldDecks(() => cardLoader.loadAll()); // this loads the decks from the card files, after the test data so the test deck is always deck 1
         });
+      }));
+    });
+  } // This function is to make the database and insert test data
 
-        cards["whiteCards"].forEach(text => {
-          if(text.length > 100) return;
-          if(text.split(" ").find(word => word.length > 20)) return;
-          db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (3, true, 0, ?)", [striptags(text)], (err) => {
-            if(err) return console.log(`Error inserting card into datbase: ${err}`);
-          });
+// CREATE TABLE IF NOT EXISTS doesn't change a table that's already in a database file, so the Deck columns added since the first version are added here
+const addedDeckColumns = {"approved": "BOOLEAN DEFAULT true", "official": "BOOLEAN DEFAULT false", "description": "TEXT", "icon": "TEXT"};
+function addMissingColumns(callback){
+  db.all("PRAGMA table_info(Deck)", (err, columns) => {
+    if(err) console.log(`Error checking the Deck columns: ${err}`);
+    let existing = (columns || []).map(column => column.name);
+    db.serialize(() => {
+      Object.keys(addedDeckColumns).filter(column => !existing.includes(column)).forEach((column) => {
+        console.log(`Adding the ${column} column to Deck`);
+        db.run(`ALTER TABLE Deck ADD COLUMN ${column} ${addedDeckColumns[column]}`, (err) => {
+          if(err) console.log(`Error adding the ${column} column to Deck: ${err}`);
         });
       });
+      db.get("SELECT 1", callback); // this runs after the columns have been added
     });
-  } // This function is to make the database and insert test data
+  });
+}
 
 /* Test Data 
 Login: {"action": "login", "username": "coolKid69", "password":"yeet"}
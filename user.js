@@ -1,10 +1,37 @@
 const crypto = require('crypto');
+var striptags = require('striptags');
+const closeCodes = require('./closeCodes.js');
+
+// clients send "protocol version" when they log in or resume, clients from before it was added don't send one and are version 1
+// the version goes up when a message changes in a way an older client would get wrong, and minProtocolVersion goes up when the server stops supporting the old way
+const protocolVersion = 4; // 3 added acks, 4 added "ping" messages
+const ackProtocolVersion = 3; // older clients don't know to send acks
+const pingProtocolVersion = 4;
+const minProtocolVersion = 1;
+const handshakeActions = ["login", "sign in as guest", "register", "resume"];
+// each connection can send MESSAGE_RATE messages a second, with bursts of up to MESSAGE_BURST
+// the first message over gets a warning, after that they're ignored, and if it carries on for maxMessagesOverLimit messages the connection is closed
+const messageRate = parseFloat(process.env.MESSAGE_RATE) || 10;
+const messageBurst = parseInt(process.env.MESSAGE_BURST) || 20;
+const maxMessagesOverLimit = 50;
+// when someone logs in to an account that's already signed in, DUPLICATE_SESSION_POLICY "newest" (the default) moves them over to the new connection
+// and closes the old one, "reject" refuses the new login
+const duplicateSessionPolicy = process.env.DUPLICATE_SESSION_POLICY == "reject" ? "reject" : "newest";
+const requestIDWindow = 5*60*1000; // a request with the same ID as one in the last 5 minutes is a retry, so it's not done again
+// messages that can't be missed, like the round starting, need an ack within ACK_TIMEOUT milliseconds or they're sent again
+const ackTimeout = parseInt(process.env.ACK_TIMEOUT) || 10*1000;
+const maxAckRetries = 2;
+const sentMessagesKept = 100; // the last few messages are kept so they can be sent again if the user reconnects
+// when a slow connection has this many bytes waiting to be sent, new messages aren't added to it
+// SEND_OVERFLOW_POLICY "resync" drops them and sends the whole game once it's caught up, "disconnect" closes the connection
+const maxSendBuffer = parseInt(process.env.MAX_SEND_BUFFER) || 1024*1024;
+const sendOverflowPolicy = process.env.SEND_OVERFLOW_POLICY == "disconnect" ? "disconnect" : "resync";
 
 module.exports = class User {
     constructor(ws, container){
         // Score, cards, lastDataSent and other game specific data needs to be put in game class, shouldn't be here
-        this.ws = ws; // maybe .clone()?
         this.container = container;
+        this.ip = ""; // this is set by the container when the user connects, for rate limiting
         this.signedIn = false;
         this.inGame = false;
         this.username = "";
@@ -12,32 +39,143 @@ module.exports = class User {
         this.email = "";
         this.userID = -1;
         this.admin = false;
-        this.ws.on('message', (message) => { // handles the incoming WS messages
-            this.processIncomingMessage(message);
+        this.notificationPreferences = {"chat": true, "reactions": true, "presence": true}; // the categories of messages the user wants, turning them off saves bandwidth on mobile
+        this.compactMode = false; // when this is on, game updates leave out the settings and deck details, for slow connections
+        this.connectionID = crypto.randomBytes(4).toString('hex'); // this is put in every log line for the user so their errors can be found in the logs
+        this.messageCount = 0;
+        this.correlationID = this.connectionID; // this becomes connectionID-messageCount whenever a message comes in
+        this.sessionToken = crypto.randomBytes(16).toString('hex'); // this is sent when they log in, it lets them carry on where they were if they get disconnected
+        this.protocolVersion = 1; // this is set to the version the client says it speaks when it logs in
+        this.sequence = 0; // every message sent to the user has a number one higher than the last
+        this.sentMessages = [];
+        this.disconnectedAt = null;
+        this.removed = false; // set by the container when the user is removed, so it's only done once
+        this.needsResync = false; // this is set when messages are dropped because the connection is too slow
+        this.pingSentAt = null;
+        this.latency = null; // in milliseconds, it's shown to the other players in their game
+        this.pingID = 0;
+        this.appPingSentAt = null;
+        this.messageTokens = messageBurst; // a message uses one of these, they're topped up over time
+        this.tokensToppedUpAt = Date.now();
+        this.messagesOverLimit = 0;
+        this.requestID = null; // the ID of the request being done, if the client gave one
+        this.command = null; // the action and request being done, errors have it in them
+        this.recentRequestIDs = new Map(); // request ID => when it was recieved
+        this.pendingAcks = new Map(); // the sequence numbers of messages that need an ack and haven't had one yet
+        this.attachWebSocket(ws);
+        // this sends the amount of games running and players in game to the user, so it can be displayed on the login page
+        this.returnMessage("update", true, {"users online": this.container.getGamesCount(), "games running": this.container.getGamesCount(), "protocol version": protocolVersion, "min protocol version": minProtocolVersion});
+    }
+    attachWebSocket(ws){ // this is seperate from the constructor so a user can carry on with a new websocket after reconnecting
+        this.ws = ws;
+        this.ws.on('message', (message, isBinary) => { // handles the incoming WS messages
+            // newer versions of ws say if it's binary, older ones give binary messages as a Buffer and text as a string
+            if(isBinary === true || (isBinary === undefined && typeof message != "string")){
+                this.log("Binary message recieved, closing the connection");
+                return this.ws.close(closeCodes["binary message"], "only text messages are supported");
+            }
+            this.processIncomingMessage(message.toString());
         });
-        this.ws.on('close', () => { // whenever a users websocket disconnects, they get removed from the game
-            this.container.removeUser(this);
+        this.ws.on('close', () => { // whenever a users websocket disconnects, they get removed from the game after a little while if they don't come back
+            this.container.userDisconnected(this);
         });
-        // this sends the amount of games running and players in game to the user, so it can be displayed on the login page
-        this.returnMessage("update", true, {"users online": this.container.getGamesCount(), "games running": this.container.getGamesCount()});
+        this.lastPongAt = Date.now(); // the container pings every connection, if this gets too old the connection has dropped without closing
+        this.lastActiveAt = Date.now(); // when the client last sent something itself, for closing idle connections
+        this.ws.on('ping', () => {
+            this.lastActiveAt = Date.now();
+        });
+        this.ws.on('pong', () => {
+            this.lastPongAt = Date.now();
+            // how long the ping took there and back, clients that answer "ping" messages get it from those instead as that includes the time the client takes
+            if(this.pingSentAt && this.protocolVersion < pingProtocolVersion) this.latency = this.lastPongAt-this.pingSentAt;
+        });
+    }
+    sendBufferFull(){
+        this.container.droppedMessages++;
+        if(sendOverflowPolicy == "disconnect"){
+            this.log(`Send buffer full (${this.ws.bufferedAmount} bytes), closing the connection`);
+            return this.ws.close(closeCodes["send buffer full"], "connection too slow"); // they can resume with their session token
+        }
+        if(!this.needsResync) this.log(`Send buffer full (${this.ws.bufferedAmount} bytes), dropping messages until it's caught up`);
+        this.needsResync = true;
+    }
+    catchUp(){ // after messages were dropped, the game is sent again so nothing is missing
+        if(!this.needsResync || this.ws.readyState != 1 || this.ws.bufferedAmount > maxSendBuffer) return;
+        this.needsResync = false;
+        let game = this.getGame();
+        let player = game && game.players.find(player => player.user == this);
+        if(player) game.resync(player);
+    }
+    checkProtocolVersion(version){
+        if(version === undefined) version = 1; // the clients from before versions were added
+        if(!Number.isInteger(version)){
+            this.returnMessage("error", true, "invalid request, protocol version should be a whole number");
+            return false;
+        }
+        if(version < minProtocolVersion){
+            this.returnMessage("error", false, "The Game Has Been Updated! Refresh The Page To Keep Playing", "client outdated");
+            return false;
+        }
+        if(version > protocolVersion){ // the client is newer than the server, like while a new version is being rolled out
+            this.returnMessage("error", false, "The Server Is Being Updated! Try Again In A Minute", "server outdated");
+            return false;
+        }
+        this.protocolVersion = version;
+        return true;
+    }
+    sendPing(){ // the client answers with {"action": "pong", "id": n}, these aren't numbered or kept as there's no point sending them again
+        if(this.protocolVersion < pingProtocolVersion || this.ws.readyState != 1) return;
+        this.pingID++;
+        this.appPingSentAt = Date.now();
+        this.ws.send(JSON.stringify({"event": "ping", "id": this.pingID, "version": protocolVersion}));
+    }
+    waitForAck(sequence){
+        this.pendingAcks.set(sequence, 0); // sequence => how many times it's been sent again
+        setTimeout(() => this.checkAck(sequence), ackTimeout);
+    }
+    checkAck(sequence){ // if the message hasn't been acked, it's sent again, and if that doesn't work they get the whole game again
+        if(!this.pendingAcks.has(sequence)) return; // it was acked
+        let retries = this.pendingAcks.get(sequence);
+        if(this.ws.readyState != 1) return this.pendingAcks.delete(sequence); // they get everything they missed when they resume
+        let sent = this.sentMessages.find(sent => sent.sequence == sequence);
+        if(sent && retries < maxAckRetries && this.ws.bufferedAmount <= maxSendBuffer){
+            this.log(`No ack for message ${sequence}, sending it again`);
+            this.pendingAcks.set(sequence, retries+1);
+            this.ws.send(sent.message);
+            return setTimeout(() => this.checkAck(sequence), ackTimeout);
+        }
+        this.log(`No ack for message ${sequence} after ${retries} retries, resyncing`);
+        this.pendingAcks.delete(sequence);
+        this.needsResync = true;
+        this.catchUp();
+    }
+    resendMessagesAfter(lastSequence){ // returns false if some of the messages after lastSequence aren't kept anymore
+        if(lastSequence < this.sequence && (this.sentMessages.length == 0 || this.sentMessages[0].sequence > lastSequence+1)) return false;
+        this.sentMessages.filter(sent => sent.sequence > lastSequence).forEach(sent => this.ws.send(sent.message));
+        return true;
     }
     signInAsGuest(){
         this.signedIn = true;
         this.username = this.container.getGuestUsername();
-        return this.returnMessage("update", true, {"logged in": true, "games running": this.container.getGames(), "username": this.username});
+        return this.returnMessage("update", true, {"logged in": true, "games running": this.container.getGames(), "username": this.username, "session token": this.sessionToken});
     }
     login(username, password){
-        if(this.signedIn) return this.returnMessage("error", true, "already signed in");
+        if(this.signedIn) return this.returnMessage("error", true, "already signed in", "already signed in");
         if(!username || !password) return this.returnMessage("error", true, "missing varible");
-        if(username.length <= 5 || username.length >= 20) return this.returnMessage("error", true, "invalid username");
+        if(typeof username != "string" || typeof password != "string") return this.returnMessage("error", true, "invalid username or password");
+        if(username.length <= 5 || username.length >= 20) return this.returnMessage("error", true, "invalid username", "invalid username");
         this.container.db.get("SELECT * FROM User WHERE username = ? COLLATE NOCASE", [username], (err, row) => { // searches for the user with the given username, not sensitive to caps
             if(err) return console.log(`Error with user class, login: ${err.message}`);
-            // checks to see if the user is already signed in
-            if(this.container.users.find(user => user.username == username)) return this.returnMessage("error", false, "User Already Signed In!");
             // If no row is found, no user has that username
-            if(!row) return this.returnMessage("error", false, "No User Has This Username");
+            if(!row) return this.returnMessage("error", false, "No User Has This Username", "user not found");
             // if the given hashed password is not the same as the hashed database password
-            if(crypto.createHmac('sha256', password).digest('hex') != row.password) return this.returnMessage("error", false, "Incorrect Password");
+            if(crypto.createHmac('sha256', password).digest('hex') != row.password) return this.returnMessage("error", false, "Incorrect Password", "incorrect password");
+            // checks to see if the user is already signed in, like in another tab
+            let existing = this.container.users.find(user => user != this && user.signedIn && user.userID == row.userID);
+            if(existing){
+                if(duplicateSessionPolicy == "reject") return this.returnMessage("error", false, "User Already Signed In!", "already signed in");
+                return this.container.takeOverSession(existing, this); // this connection carries on as the signed in user, in their game
+            }
             // sets the attributes
             this.username = username;
             this.signedIn = true;
@@ -45,7 +183,8 @@ module.exports = class User {
             this.userID = row.userID;
             this.admin = row.admin;
             // need to send games running and basic stats about them            
-            return this.returnMessage("update", true, {"logged in": true, "games running": this.container.getGames()});
+            this.returnMessage("update", true, {"logged in": true, "games running": this.container.getGames(), "session token": this.sessionToken});
+            return this.container.restorePausedGame(this); // puts them back in their game if the server restarted while they were playing
         });
     }
     logOut(){
@@ -59,17 +198,20 @@ module.exports = class User {
             this.admin = false;
             return this.returnMessage("done", true, "logged out");
         } else { // if they're not signed in, give back an error (used mainly for debugging)
-            return this.returnMessage("error", true, "user not signed in");
+            return this.returnMessage("error", true, "user not signed in", "not signed in");
         }
     }
     register(username, password, email){
         if(!username || !password || !email) return this.returnMessage("error", true, "missing var"); // checks to see if all varibles are there
         // checks to see if the given varibles are valid
         // these are already checked at the client side level, however, anything sent from the client can be anything they want it to be so it cant be trusted
-        if(this.signedIn) return this.returnMessage("error", true, "signed in already, cant register"); // used mainly for debugging, if they're signed in, they cant register
-        if(username.length <= 0 || username.length > 20) return this.returnMessage("error", true, "invalid username"); // username length checks
-        if(!this.validateEmail(email)) return this.returnMessage("error", true, "invalid email"); // validates email
-        if(!this.validatePassword(password)) return this.returnMessage("error", true, "invalid password"); // validates password
+        if(this.signedIn) return this.returnMessage("error", true, "signed in already, cant register", "already signed in"); // used mainly for debugging, if they're signed in, they cant register
+        if(typeof username != "string") return this.returnMessage("error", true, "invalid username", "invalid username"); // a number has no length, so it would get past the length check
+        if(username.length <= 0 || username.length > 20) return this.returnMessage("error", true, "invalid username", "invalid username"); // username length checks
+        let nameError = this.container.checkName(username); // checks for swear words and names like "Server"
+        if(nameError) return this.returnMessage("error", false, nameError, "username not allowed");
+        if(!this.validateEmail(email)) return this.returnMessage("error", true, "invalid email", "invalid email"); // validates email
+        if(!this.validatePassword(password)) return this.returnMessage("error", true, "invalid password", "invalid password"); // validates password
 
         this.container.db.get("SELECT * FROM User WHERE username = ? OR email = ?", [username, email], (err, row) => {
             if(err) return console.log(`Error with SQL check for email or username: ${err.message}`);
@@ -81,44 +223,163 @@ module.exports = class User {
                 });
             } else { // if there is a username or email already registered with that name
                 if(row.username == username){ // if the username or email is taken, this will be told to the user
-                    return this.returnMessage("error", false, "The Username Is Taken");
+                    return this.returnMessage("error", false, "The Username Is Taken", "username taken");
                 } else if(row.email == email){
-                    return this.returnMessage("error", false, "The Email Is Already Registered");
+                    return this.returnMessage("error", false, "The Email Is Already Registered", "email taken");
                 }
             }
         });
     }
     changeUsername(newUsername){
         // checks to see if the length is valid
-        if(newUsername.length <= 6 || newUsername.length > 20) return this.returnMessage("error", true, "username invalid");
+        if(typeof newUsername != "string") return this.returnMessage("error", true, "invalid username", "invalid username");
+        if(newUsername.length <= 6 || newUsername.length > 20) return this.returnMessage("error", true, "username invalid", "invalid username");
+        let nameError = this.container.checkName(newUsername);
+        if(nameError) return this.returnMessage("error", false, nameError, "username not allowed");
         if(this.signedIn){ // checks to see if the user is signed in
             this.container.db.get("UPDATE User SET username = ? WHERE userID = ?", [newUsername, this.userID]); // updates the username in the DB
             this.username = newUsername; // updates the username in the user instance
             this.container.printDatabase();
             return this.returnMessage("done", true, "username changed");
         } else {
-            return this.returnMessage("error", true, "user not signed in");
+            return this.returnMessage("error", true, "user not signed in", "not signed in");
         }
     }
-    returnMessage(type, internal, content){
+    returnMessage(type, internal, content, code){
         // types: error, done, message, update
-        console.log(`Event: ${type}, internal?:${internal},\ncontent: ${JSON.stringify(content)}`); // console logs this for debugging
-        this.ws.send(JSON.stringify({"event": type, "internal": internal, "content": content}));// sends the data to the user
+        // errors should have a code too, so the client can react to them (like re-enabling a button) or show its own translated text
+        this.sendPreparedMessage(type, internal, JSON.stringify(content), false, code);
+    }
+    sendPreparedMessage(type, internal, contentJSON, ackRequired = false, code){ // the content is already JSON, so a game can turn a message into JSON once and send it to everyone
+        this.log(`Event: ${type}, internal?:${internal},\ncontent: ${contentJSON}`); // console logs this for debugging
+        let messageJSON = `{"event":${JSON.stringify(type)},"internal":${JSON.stringify(internal)},"content":${contentJSON},"sequence":${++this.sequence},"version":${protocolVersion}`;
+        if(ackRequired && this.protocolVersion >= ackProtocolVersion){ // the client has to send {"action": "ack", "sequence": n} back, or it's sent again
+            messageJSON += `,"ack required":true`;
+            this.waitForAck(this.sequence);
+        }
+        if(type == "error"){
+            messageJSON += `,"correlation id":${JSON.stringify(this.correlationID)}`; // so a user reporting an error can give the ID to find it in the logs
+            messageJSON += `,"code":${JSON.stringify(code || (internal ? "invalid request" : "error"))}`; // errors sent without a code get a generic one
+            if(this.command) messageJSON += `,"command":${JSON.stringify(this.command)}`; // what they sent that caused the error
+        }
+        if(this.requestID) messageJSON += `,"request id":${JSON.stringify(this.requestID)}`;
+        messageJSON += "}";
+        this.sentMessages.push({"sequence": this.sequence, "message": messageJSON}); // it's kept even if they're disconnected, so it can be sent when they come back
+        if(this.sentMessages.length > sentMessagesKept) this.sentMessages.shift();
+        if(this.ws.readyState != 1) return;
+        if(this.ws.bufferedAmount > maxSendBuffer) return this.sendBufferFull();
+        if(this.needsResync) this.catchUp();
+        this.container.messagesSent++;
+        this.ws.send(messageJSON, (err) => { // sends the data to the user
+            if(!err) return;
+            this.container.sendErrors++;
+            this.log(`Error sending message: ${err}`);
+        });
+    }
+    sendNotification(category, type, internal, content){ // this is returnMessage for messages the user can turn off in their notification preferences
+        if(!this.notificationPreferences[category]) return;
+        this.returnMessage(type, internal, content);
+    }
+    sendPreparedNotification(category, type, internal, contentJSON){
+        if(!this.notificationPreferences[category]) return;
+        this.sendPreparedMessage(type, internal, contentJSON);
+    }
+    setNotificationPreferences(preferences){
+        if(typeof preferences != "object" || preferences === null) return this.returnMessage("error", true, "invalid request, preferences should be an object");
+        for(let category of Object.keys(preferences)){ // checks them all before changing any
+            if(!this.notificationPreferences.hasOwnProperty(category)) return this.returnMessage("error", true, `invalid request, ${category} is not a notification category`);
+            if(typeof preferences[category] != "boolean") return this.returnMessage("error", true, "invalid request, preferences should be true or false");
+        }
+        Object.assign(this.notificationPreferences, preferences);
+        return this.returnMessage("update", true, {"notification preferences": this.notificationPreferences});
+    }
+    log(text){ // console.log, but with the correlation ID at the start so all the lines for a request can be found
+        console.log(`[${this.correlationID}] ${text}`);
     }
     
     getGame(){ // returns the game the user is in, I intend to have user.game instead of this at some point
-        for(var i =0; i < this.container.games.length; i++){ // for each game in container.games
-            if(this.container.games[i].players.find(player => player.user === this)) return this.container.games[i]; // if the player is found in the game, return the game
+        let games = this.container.games.list();
+        for(var i =0; i < games.length; i++){ // for each game in the container
+            if(games[i].players.find(player => player.user === this)) return games[i]; // if the player is found in the game, return the game
         }
         return false; // if there is no game found, return false
     }
+    takeMessageToken(){ // returns false if the connection is sending too many messages
+        if(this.container.sandbox) return true; // limits are off in the sandbox
+        let now = Date.now();
+        this.messageTokens = Math.min(messageBurst, this.messageTokens+(now-this.tokensToppedUpAt)/1000*messageRate);
+        this.tokensToppedUpAt = now;
+        if(this.messageTokens == messageBurst) this.messagesOverLimit = 0; // they've slowed down, so they start again from a warning
+        if(this.messageTokens >= 1){
+            this.messageTokens--;
+            return true;
+        }
+        this.messagesOverLimit++;
+        this.container.messagesRateLimited++;
+        if(this.messagesOverLimit == 1){
+            this.log("Sending too many messages, warning them");
+            this.returnMessage("error", false, "Slow Down! You're Sending Too Many Messages", "rate limited");
+        } else if(this.messagesOverLimit > maxMessagesOverLimit){
+            this.log("Carried on sending too many messages, closing the connection");
+            this.ws.close(closeCodes["too many messages"], "too many messages");
+        }
+        return false;
+    }
     processIncomingMessage(message){
+        this.container.messagesReceived++;
+        if(!this.takeMessageToken()) return; // the message is ignored
+        this.messageCount++;
+        this.correlationID = `${this.connectionID}-${this.messageCount}`;
+        if(this.container.sandbox) this.log(`Recieved: ${message}`); // in the sandbox both sides of the protocol are logged, sent messages are always logged in returnMessage
         try{ // If the given JSON is invalid, an error will be returned
             var msgData = JSON.parse(message);
         } catch(e) { 
-            return this.returnMessage("error", true, "JSON invalid"); // returns error, mainly for debugging
+            return this.returnMessage("error", true, "JSON invalid", "invalid json"); // returns error, mainly for debugging
+        }
+        if(typeof msgData != "object" || msgData === null) return this.returnMessage("error", true, "invalid request");
+        let requestID = msgData["request id"];
+        if(requestID !== undefined){ // the client can give each request an ID, so if it sends it again after a connection problem it isn't done twice
+            if(typeof requestID != "string" || requestID.length == 0 || requestID.length > 64) return this.returnMessage("error", true, "invalid request, request id should be a string up to 64 characters");
+            let now = Date.now();
+            this.recentRequestIDs.forEach((at, id) => {
+                if(now-at > requestIDWindow) this.recentRequestIDs.delete(id);
+            });
+            if(this.recentRequestIDs.has(requestID)){
+                this.requestID = requestID;
+                this.returnMessage("error", true, "duplicate request, it has already been done", "duplicate request");
+                this.requestID = null;
+                return;
+            }
+            this.recentRequestIDs.set(requestID, now);
         }
+        this.requestID = requestID || null; // the messages sent while this request is being done have the ID in them
+        this.command = {"action": msgData.action, "request": msgData.request};
+        this.processRequest(msgData);
+        this.requestID = null;
+        this.command = null;
+    }
+    processRequest(msgData){
         if(!msgData.action) return this.returnMessage("error", true, "invalid request"); // all messages need to have an "action", this says what they are for
+        if(handshakeActions.includes(msgData.action) && !this.checkProtocolVersion(msgData["protocol version"])) return;
+        if(msgData.action == "pong"){ // the answer to a "ping" message
+            if(msgData.id === this.pingID && this.appPingSentAt) this.latency = Date.now()-this.appPingSentAt; // older pongs are ignored, they'd be wrong
+            return;
+        }
+        this.lastActiveAt = Date.now(); // pongs are sent by the client by itself, so they don't stop an idle connection being closed
+        if(msgData.action == "ack"){ // the client got a message that needed an ack, no reply is sent to this
+            if(!Number.isInteger(msgData.sequence)) return this.returnMessage("error", true, "invalid request, no sequence");
+            return this.pendingAcks.delete(msgData.sequence);
+        }
+        if(msgData.action == "resume"){ // for reconnecting, this is instead of logging in
+            if(typeof msgData["session token"] != "string" || !Number.isInteger(msgData["last sequence"])) return this.returnMessage("error", true, "invalid request, no session token or last sequence");
+            if(this.signedIn || this.getGame()) return this.returnMessage("error", true, "already signed in, resume is for new connections", "already signed in"); // this connection would be dropped, leaving its player behind in the game
+            return this.container.resumeSession(this, msgData["session token"], msgData["last sequence"]);
+        }
+        if(msgData.action == "resync"){ // for clients that have missed messages, like after a tab was asleep, it sends everything again without reconnecting
+            let game = this.getGame();
+            if(game) return game.resync(game.players.find(player => player.user == this));
+            return this.returnMessage("update", true, {"logged in": this.signedIn, "username": this.username, "games running": this.signedIn ? this.container.getGames() : [], "session token": this.sessionToken});
+        }
         if(msgData.action == "login"){
             this.login(msgData.username, msgData.password);
         } else if(msgData.action == "sign in as guest"){
@@ -138,22 +399,23 @@ module.exports = class User {
             } else {
                 if(!msgData.request) return this.returnMessage("error", true, "invalid request"); // if there is no request and the action is game, its an invalid request
                 if(msgData.request == "join game"){
-                    if(!this.signedIn) return this.returnMessage("error", true, "user not signed in"); // checks if the user is signed in before they can join a game
+                    if(!this.signedIn) return this.returnMessage("error", true, "user not signed in", "not signed in"); // checks if the user is signed in before they can join a game
                     // checks the request to see if its all valid
-                    if(!msgData["game name"]) return this.returnMessage("error", true, "invalid request, no game name");
-                    let game = this.container.games.find(game => game.gameName == msgData["game name"]);
-                    if(!game) return this.returnMessage("error", true, "game does not exist");
-                    if(!game.joinable) return this.returnMessage("error", true, "game is not joinable");
-                    if(this.getGame()) return this.returnMessage("error", true, "user already in game");
+                    if(!msgData["game name"] && typeof msgData["join code"] != "string") return this.returnMessage("error", true, "invalid request, no game name or join code");
+                    let game = typeof msgData["join code"] == "string" ? this.container.games.getByJoinCode(msgData["join code"].trim()) : this.container.games.get(msgData["game name"]);
+                    if(!game) return this.returnMessage("error", true, "game does not exist", "game not found");
+                    if(!game.joinable) return this.returnMessage("error", true, "game is not joinable", "game not joinable");
+                    if(this.getGame()) return this.returnMessage("error", true, "user already in game", "already in game");
                     if(game.private){ // if the game is private, check for password
-                        if(!msgData.password) return this.returnMessage("error", true, "no game password provided for private game");
-                        if(msgData.password != game.password) return this.returnMessage("error", false, "Incorrect Password!");
+                        if(!this.container.checkRateLimit(this, "join private game")) return;
+                        if(!msgData.password) return this.returnMessage("error", true, "no game password provided for private game", "game password required");
+                        if(msgData.password != game.password) return this.returnMessage("error", false, "Incorrect Password!", "incorrect game password");
                             game.addPlayer(this);
                     } else {
                         game.addPlayer(this);
                     }
                 } else {
-                    this.returnMessage("error", true, "not in game");
+                    this.returnMessage("error", true, "not in game", "not in game");
                 }
             }
         } else if(msgData.action == "update"){
@@ -165,59 +427,135 @@ module.exports = class User {
             } else if(msgData.request == "change password"){
                 if(!msgData.password) return user.returnMessage("error", true, "invalid request");
                 this.changePassword(msgData.password);
+            } else if(msgData.request == "notification preferences"){
+                if(!msgData.preferences) return this.returnMessage("error", true, "invalid request");
+                this.setNotificationPreferences(msgData.preferences);
+            } else if(msgData.request == "compact mode"){
+                if(typeof msgData.enabled != "boolean") return this.returnMessage("error", true, "invalid request");
+                this.compactMode = msgData.enabled;
+                let game = this.getGame();
+                if(game){ // the next update needs to have everything in it, as the format has changed
+                    game.players.find(player => player.user == this).lastDataSent = {game:{}};
+                    game.broadcastGameData();
+                }
+                this.returnMessage("done", true, `compact mode ${this.compactMode ? "on" : "off"}`);
             } else if(msgData.request == "add new deck"){
                 if(!msgData.deck) return user.returnMessage("error", true, "invalid request");
-                this.addDeck(msgData.deck, msgData.private);
+                this.addDeck(msgData.deck, msgData.private, msgData.nsfw);
+            } else if(msgData.request == "edit deck"){
+                if(!msgData.deckID || !msgData.deck) return this.returnMessage("error", true, "invalid request");
+                this.editDeck(msgData.deckID, msgData.deck);
+            } else if(msgData.request == "publish deck"){
+                if(!msgData.deckID) return this.returnMessage("error", true, "invalid request");
+                this.publishDeck(msgData.deckID);
+            } else if(msgData.request == "report deck"){
+                if(!this.signedIn || !msgData.deckID) return this.returnMessage("error", true, "invalid request");
+                this.reportDeck(msgData.deckID, msgData.reason);
             }
         }
     }
-    addDeck(deck, privateBool){ // privateBool would have been "private", but javascript doesn't like that
+    validateDeck(deck){ // returns what is wrong with the deck, or an empty string if it's fine
+        if(!deck || !deck.name || !deck["white cards"] || !deck["black cards"]) return "invalid request, no name or whiteCards or blackCards";
+        if(deck.name.length > 20 || deck.name.length < 4) return "invalid deck name length";
+        if(!Array.isArray(deck["white cards"]) || !Array.isArray(deck["black cards"])) return "invalid request, whiteCards or blackCards is not an array";
+        return "";
+    }
+    addDeck(deck, privateBool, nsfw){ // privateBool would have been "private", but javascript doesn't like that
         //try{ // checks to see if the JSON is valid
         //var deck = JSON.parse(deckInJSON);
         //} catch(e){
-            //return this.returnMessage("error", false, "Invalid JSON!");
+            //return this.returnMessage("error", false, "Invalid JSON!", "invalid json");
         //}
         // checks some basic varibles of the object
-        if(!deck.name || !deck["white cards"] || !deck["black cards"]) return this.returnMessage("error", true, "invalid request, no name or whiteCards or blackCards");
-        if(deck.name > 20 || deck.name < 4) return this.returnMessage("error", true, "invalid deck name length");
-        if(!Array.isArray(deck["white cards"]) || !Array.isArray(deck["black cards"])) return this.returnMessage("error", true, "invalid request, whiteCards or blackCards is not an array");
+        let deckError = this.validateDeck(deck);
+        if(deckError) return this.returnMessage("error", true, deckError);
         this.container.db.get("SELECT * FROM Deck WHERE name = ?", [deck.name], (err, row) => {
             if(err) return console.log("error with addDeck, SQL query to find if deck name is unique: "+err);
-            if(row) return this.returnMessage("error", false, "Deck Name Is Already In Use! Choose A Different Name");
+            if(row) return this.returnMessage("error", false, "Deck Name Is Already In Use! Choose A Different Name", "deck name taken");
             this.container.db.serialize(() => {
-                this.container.db.run("INSERT INTO Deck (userID, time, name, public) VALUES (?, ?, ?, ?)", [this.userID, Date.now(), deck.name, privateBool], (err, row) => {
+                // public decks have to be approved by an admin before other people can see them
+                this.container.db.run("INSERT INTO Deck (userID, time, name, public, nsfw, approved) VALUES (?, ?, ?, ?, ?, false)", [this.userID, Date.now(), deck.name, !privateBool, nsfw !== false], (err, row) => {
                     if(err) console.log("error inserting deck into database");
                 });
                 this.container.db.get("SELECT deckID FROM Deck WHERE name = ?", deck.name, (err, row) => {
                     if(err) console.log("error inserting deck into database");
-                    deck["white cards"].forEach((card) => {
-                        this.container.db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (?, true, 0, ?)", [row.deckID, card]);
-                    });
-                    deck["black cards"].forEach((card) => {
-                        this.container.db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (?, false, ?, ?)", [row.deckID, card.cardsToPick, card.cardText]);
+                    this.insertDeckCards(row.deckID, deck);
+                    return this.returnMessage("done", false, privateBool ? "Deck Has Been Added!" : "Deck Has Been Added! It Will Be Public Once It Has Been Approved");
+                });
+            });
+        });
+    }
+    insertDeckCards(deckID, deck){
+        deck["white cards"].forEach((card) => {
+            this.container.db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (?, true, 0, ?)", [deckID, card]);
+        });
+        deck["black cards"].forEach((card) => {
+            this.container.db.run("INSERT INTO Card (deckID, cardType, cardsToPick, cardText) VALUES (?, false, ?, ?)", [deckID, card.cardsToPick, card.cardText]);
+        });
+    }
+    getOwnDeck(deckID, callback){ // runs the callback with the deck if this user made it, otherwise sends an error
+        this.container.db.get("SELECT * FROM Deck WHERE deckID = ?", [deckID], (err, row) => {
+            if(err) return console.log(`Error with get own deck SQL query: ${err}`);
+            if(!row) return this.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
+            if(row.userID === null) return this.returnMessage("error", false, "The Decks From The Card Files Can't Be Changed!", "not deck owner"); // they have no owner
+            if(row.userID != this.userID || this.userID == -1) return this.returnMessage("error", false, "You Can Only Change Your Own Decks!", "not deck owner");
+            callback(row);
+        });
+    }
+    editDeck(deckID, deck){ // replaces the name and cards of the deck
+        let deckError = this.validateDeck(deck);
+        if(deckError) return this.returnMessage("error", true, deckError);
+        this.getOwnDeck(deckID, (row) => {
+            this.container.db.get("SELECT deckID FROM Deck WHERE name = ? AND deckID != ?", [deck.name, deckID], (err, nameRow) => {
+                if(err) return console.log(`Error with edit deck SQL query: ${err}`);
+                if(nameRow) return this.returnMessage("error", false, "Deck Name Is Already In Use! Choose A Different Name", "deck name taken");
+                this.container.db.serialize(() => {
+                    this.container.db.run("BEGIN TRANSACTION");
+                    this.container.db.run("UPDATE Deck SET name = ?, approved = false WHERE deckID = ?", [deck.name, deckID]); // edited public decks need approving again
+                    this.container.db.run("DELETE FROM Card WHERE deckID = ?", [deckID]);
+                    this.insertDeckCards(deckID, deck);
+                    this.container.db.run("COMMIT", (err) => {
+                        if(err) return console.log(`Error editing deck: ${err}`);
+                        this.returnMessage("done", false, row.public ? "Deck Updated! It Will Be Public Again Once It Has Been Approved" : "Deck Updated!");
                     });
-                    return this.returnMessage("done", false, "Deck Has Been Added!");
                 });
             });
         });
     }
+    publishDeck(deckID){
+        this.getOwnDeck(deckID, (row) => {
+            if(row.public) return this.returnMessage("error", false, "That Deck Is Already Public!", "deck already public");
+            this.container.db.run("UPDATE Deck SET public = true, approved = false WHERE deckID = ?", [deckID], (err) => {
+                if(err) return console.log(`Error publishing deck: ${err}`);
+                this.returnMessage("done", false, "Deck Published! It Will Be Public Once It Has Been Approved");
+            });
+        });
+    }
+    reportDeck(deckID, reason){
+        this.container.db.get("SELECT name FROM Deck WHERE deckID = ? AND public = true AND approved = true", [deckID], (err, row) => {
+            if(err) return console.log(`Error with report deck SQL query: ${err}`);
+            if(!row) return this.returnMessage("error", false, "That Deck Does Not Exist!", "deck not found");
+            this.container.reportContent({"type": "deck", "deckID": deckID, "name": row.name, "reported by": this.username, "reason": striptags(reason || "")});
+            this.returnMessage("done", false, "Deck Reported!");
+        });
+    }
     changeEmail(newEmail){
-        if(!this.signedIn) return this.returnMessage("error", true, "invalid request, email cannot be changed when user is not signed in");
+        if(!this.signedIn) return this.returnMessage("error", true, "invalid request, email cannot be changed when user is not signed in", "not signed in");
         
         /*
-        if(email.length <= 0 || email.length > 60) return this.returnMessage("error", true, "invalid email length");
-        if(email.indexOf('@') < 1 || email.indexOf('@') >= email.indexOf('@') + email.indexOf('.')) return this.returnMessage("error", true, "invalid email, wrong format");
-        if(email.split('@').length != 2 || email.split('@')[1].split('.')[0].length < 1 || email.split('@')[1].split('.')[1].length < 1) return this.returnMessage("error", true, "invalid email, wrong format");
+        if(email.length <= 0 || email.length > 60) return this.returnMessage("error", true, "invalid email length", "invalid email");
+        if(email.indexOf('@') < 1 || email.indexOf('@') >= email.indexOf('@') + email.indexOf('.')) return this.returnMessage("error", true, "invalid email, wrong format", "invalid email");
+        if(email.split('@').length != 2 || email.split('@')[1].split('.')[0].length < 1 || email.split('@')[1].split('.')[1].length < 1) return this.returnMessage("error", true, "invalid email, wrong format", "invalid email");
         */
 
         // checks email validity and length
-        if(!this.validateEmail(newEmail)) return this.returnMessage("error", true, "invalid email");
+        if(!this.validateEmail(newEmail)) return this.returnMessage("error", true, "invalid email", "invalid email");
         this.container.db.get("SELECT email FROM User WHERE userID = ?", [this.userID], (err, row) => {
             if(err || !row) return console.log("SQL error with changing email: "+err); // if there is no result or an error, log it to the console
-            if(newEmail == row.email) return this.returnMessage("error", false, "invalid request, new email is the same as the old one"); // if the email is the same, its not going to be updated
+            if(newEmail == row.email) return this.returnMessage("error", false, "invalid request, new email is the same as the old one", "same email"); // if the email is the same, its not going to be updated
             this.container.db.get("SELECT userID FROM User WHERE email = ?", [newEmail], (err, row) => { // checking to see if there is another user with the same email
                 if(err) return console.log("error with SQL query checking if email is already registered in changeEmail: "+err);
-                if(row) return user.returnMessage("error", false, "Email Is Already Registered With Another Account!");
+                if(row) return user.returnMessage("error", false, "Email Is Already Registered With Another Account!", "email taken");
                 this.container.db.run("UPDATE User SET email = ? WHERE userID = ?", [newEmail, this.userID], (err) => {
                     if(err) return console.log("error with updating email: "+err); // console logs because its a database error if err is true
                     this.container.printDatabase();
@@ -228,24 +566,26 @@ module.exports = class User {
     }
     validateEmail(email){ // validation email function, I had this chunk of code repeated and decided it was better to be made a function
         // This makes sure that the email @ and . sign are there with characters inbetween so the minimum email would be L@L.L
+        if(typeof email != "string") return false;
         if(email.length <= 0 || email.length > 60) return false;
         if(email.indexOf('@') < 1 || email.indexOf('@') >= email.indexOf('@') + email.indexOf('.')) return false;
         if(email.split('@').length != 2 || email.split('@')[1].split('.')[0].length < 1 || email.split('@')[1].split('.')[1].length < 1) return false;
         return true;
     }
     validatePassword(password){
+        if(typeof password != "string") return false;
         if(password.length > 30 || password.length < 6) return false; // length check on the password
         if(!/\d/.test(password)) return false; // looks for numbers in the string, if there are none it returns false
         return true;
     }
     changePassword(newPassword){
-        if(!this.signedIn) return this.returnMessage("error", true, "invalid request, user needs to be signed in before password can be changed");
-        if(!this.validatePassword(newPassword)) return this.returnMessage("error", true, "invalid password");
+        if(!this.signedIn) return this.returnMessage("error", true, "invalid request, user needs to be signed in before password can be changed", "not signed in");
+        if(!this.validatePassword(newPassword)) return this.returnMessage("error", true, "invalid password", "invalid password");
 
         this.container.db.get("SELECT password FROM User WHERE userID = ?", [this.userID], (err, row) => { // gets the old password to see if they are the same
             if(err) return console.log("error with SQL query in changePassword: "+err);
             var passwordHash = crypto.createHmac('sha256', newPassword).digest('hex'); // hashes the password
-            if(row.password == passwordHash) return this.returnMessage("error", false, "Your New Password Cannot Be The Same As Your Old One!"); // this sends a message to the user
+            if(row.password == passwordHash) return this.returnMessage("error", false, "Your New Password Cannot Be The Same As Your Old One!", "same password"); // this sends a message to the user
             this.container.db.run("UPDATE User SET password = ? WHERE userID = ?", [passwordHash, this.userID], (err) => { // if all is good, it updates the password
                 if(err) return console.log("error with updating new password: "+err);
                 return this.returnMessage("done", false, "Password Updated!");
@@ -271,7 +611,7 @@ module.exports = class User {
     }
     setPrivateDecks(){ // obsolete
         // checks to see if the user is signed in as they need to be
-        if(!this.signedIn) return this.returnMessage("error", true, "invalid request, Cannot get decks when user is not signed in");
+        if(!this.signedIn) return this.returnMessage("error", true, "invalid request, Cannot get decks when user is not signed in", "not signed in");
         this.container.db.all("SELECT * FROM Deck WHERE userID = ? AND public = false", [this.userID], (err, rows) => { // returns an array of all the decks that the user owns and that are private
             if(err) return console.log(`Error with get decks SQL query: ${err}`);
             rows.forEach((deck) => {